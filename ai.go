@@ -1,11 +1,12 @@
 package goomo
 
-func NewMovementAI(outboundCmds chan Command) *MovementAI {
+func NewMovementAI(shaper *MotionShaper) *MovementAI {
 	mov := MovementAI{
-		OutboundCmds: outboundCmds,
-		maxAv:        0.4,
-		maxLv:        0.4,
-		direction:    0,
+		Shaper:    shaper,
+		maxAv:     0.4,
+		maxLv:     0.4,
+		direction: 0,
+		distances: SharedDistanceLookup(),
 	}
 
 	mov.setState(NewIdleState(&mov))
@@ -3,11 +3,11 @@ package goomo
 import (
 	"fmt"
 	"log"
-	"math"
 )
 
 type MovementAI struct {
-	OutboundCmds        chan Command
+	// Shaper ramps the velocities on their way to the Loomo
+	Shaper              *MotionShaper
 	InboundPostits      chan [][]Feature
 	InboundTrafficSigns chan *TrafficSignFeature
 	oldAv               float32
@@ -16,6 +16,12 @@ type MovementAI struct {
 	maxAv               float32
 	maxLv               float32
 	direction           int
+	telemetry           *TelemetryHub
+	// watchdog receives a beat of WatchAI for every decision on post-its
+	watchdog *Watchdog
+	// distances are calculated for the eye height of the robot
+	distances *DistanceLookup
+	metrics   *moduleMetrics
 }
 
 type StateId uint8
@@ -48,6 +54,8 @@ func (m *MovementAI) setState(state MovementAIState) {
 	}
 
 	log.Printf("MovementAI in state %v", state.name())
+	m.metrics.get().aiStates.With(state.name()).Inc()
+	m.telemetry.Publish(TopicAIState, AIStateTelemetry{State: state.name()})
 
 	// start new state
 	m.state = state
@@ -72,9 +80,12 @@ func (m *MovementAI) BigBrainPostitAI() {
 	lv := float32(0)
 
 	for ps := range m.InboundPostits {
+		m.metrics.get().aiInputs.With("postits").Inc()
+		m.telemetry.Publish(TopicPostits, PostitsToPositionedObjects(m.distances, ps))
 		m.trackDirection(ps)
 		lv, av = m.state.handlePostits(ps)
 		m.setVelocities(lv, av)
+		m.watchdog.Beat(WatchAI)
 	}
 
 	if m.state.id() == Uturn || m.state.id() == FollowPostits {
@@ -91,6 +102,8 @@ func (m *MovementAI) BigBrainTrafficSignAI() {
 	lv := float32(0)
 
 	for ts := range m.InboundTrafficSigns {
+		m.metrics.get().aiInputs.With("trafficsigns").Inc()
+		m.telemetry.Publish(TopicTrafficSigns, TrafficSignToPositionedObjects(ts))
 		lv, av = m.state.handleTrafficSigns(*ts)
 		m.setVelocities(lv, av)
 	}
@@ -112,20 +125,12 @@ func (m *MovementAI) trackDirection(ps [][]Feature) {
 	m.direction = signumInt(near0.imagePos.X - near1.imagePos.X)
 }
 
+// setVelocities passes the velocities to the Shaper, which clamps them to the limits.
+// After a watchdog fault they are dropped until the watchdog is reset.
 func (m *MovementAI) setVelocities(lv, av float32) {
-	if math.Abs(float64(lv)) > float64(m.maxAv) || math.Abs(float64(av)) > float64(m.maxAv) {
-		//log.Println("velocities out of bounds", lv, av)
-		return
-	}
-
-	if m.oldLv != lv {
-		//log.Printf("Set linear velocity: %v", lv)
-		m.OutboundCmds <- &CLVLCommand{Lv: lv}
-		m.oldLv = lv
-	}
-	if m.oldAv != av {
-		//log.Printf("Set angular velocity: %v", av)
-		m.OutboundCmds <- &CAVLCommand{Av: av}
-		m.oldAv = av
+	lv, av, ok := m.Shaper.SetAutonomous(lv, av)
+	if !ok {
+		lv, av = 0, 0
 	}
+	m.oldLv, m.oldAv = lv, av
 }
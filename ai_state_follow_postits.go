@@ -65,11 +65,11 @@ func (f *FollowPostitsState) handlePostits(postits [][]Feature) (lv, av float32)
 		}
 	} else {
 		var err error
-		left, err := BezierPath(pits0, -40, 0)
+		left, err := BezierPath(f.ai.distances, pits0, -40, 0)
 		if err != nil {
 			return f.ai.oldLv, f.ai.oldAv
 		}
-		right, err := BezierPath(pits1, 40, 0)
+		right, err := BezierPath(f.ai.distances, pits1, 40, 0)
 		if err != nil {
 			return f.ai.oldLv, f.ai.oldAv
 		}
@@ -91,7 +91,7 @@ func (f *FollowPostitsState) handleTrafficSigns(trafficSign TrafficSignFeature)
 	if trafficSign.Index == f.signIndex {
 		// avoid false positives
 		f.signCounter++
-		distance := SharedDistanceLookup().EuclideanToLoomDistance(trafficSign.realPos)
+		distance := f.ai.distances.EuclideanToLoomDistance(trafficSign.realPos)
 
 		if f.signCounter > f.signTreshold && distance < 100 {
 			state, err := NewMovementAIState(f.ai, &trafficSign)
@@ -48,8 +48,8 @@ func (u *UturnState) handlePostits(postits [][]Feature) (lv, av float32) {
 		return 0, u.turningVelocity
 	}
 
-	d0 := SharedDistanceLookup().EuclidianToLoomoPixel(near0.imagePos)
-	d1 := SharedDistanceLookup().EuclidianToLoomoPixel(near1.imagePos)
+	d0 := u.ai.distances.EuclidianToLoomoPixel(near0.imagePos)
+	d1 := u.ai.distances.EuclidianToLoomoPixel(near1.imagePos)
 
 	if d0 < 150 && d1 < 150 {
 		currentDirection := signumInt(near0.imagePos.X - near1.imagePos.X)
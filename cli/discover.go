@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"iteragit.iteratec.de/go_loomo_go/goomo"
+	"log"
+	"time"
+)
+
+// discover.go lists every Loomo which announces itself on the network,
+// the printed serial or address can be used as LoomoCommunicator.Target
+func main() {
+	bcPort := flag.String("broadcast", ":1336", "port on which the Loomos announce themselves")
+	timeout := flag.Duration("timeout", 5*time.Second, "time to listen for announcements")
+	flag.Parse()
+
+	loomos, err := goomo.DiscoverLoomos(*bcPort, *timeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(loomos) == 0 {
+		log.Printf("no Loomo announced itself within %v", *timeout)
+		return
+	}
+	for _, loomo := range loomos {
+		fmt.Printf("%s\t%s\n", loomo.Addr, loomo.Serial)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"iteragit.iteratec.de/go_loomo_go/goomo"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// fleet.go drives several Loomos from one process, e.g.
+// `go run fleet.go -config fleet.json alpha=SERIAL01,alpha.json beta=192.168.0.12 gamma=192.168.0.13:1337`.
+// A target with a port is dialled directly, otherwise the robot is discovered by serial or IP address.
+// Each robot loads the optional config after the comma, otherwise it uses the defaults; -config selects
+// the listen address, CORS origins and shutdown timeout of the shared HTTP endpoints.
+// The streams of each robot are received on 5 consecutive ports starting at -camera.
+// On SIGINT or SIGTERM all robots are stopped before it exits.
+func main() {
+	cameraPort := flag.Int("camera", 1339, "camera port of the first robot")
+	configPath := flag.String("config", "", "config of the HTTP endpoints")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("usage: fleet [-camera port] [-config file] id=target[,config]...")
+	}
+
+	fleetConfig := goomo.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		fleetConfig, err = goomo.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	fleet := goomo.NewFleetWithConfig(fleetConfig)
+	for i, arg := range flag.Args() {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid robot %q, want id=target[,config]", arg)
+		}
+		target := strings.SplitN(parts[1], ",", 2)
+		config := goomo.DefaultConfig()
+		if len(target) == 2 {
+			var err error
+			config, err = goomo.LoadConfig(target[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if _, _, err := net.SplitHostPort(target[0]); err == nil {
+			config.Loomo.Addr, config.Loomo.Target = target[0], ""
+		} else {
+			config.Loomo.Addr, config.Loomo.Target = "", target[0]
+		}
+		config.CameraPort = strconv.Itoa(*cameraPort + 5*i)
+		err := fleet.Add(parts[0], goomo.NewGoomoWithConfig(config))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fleet.Start(goomo.InterruptContext())
+	fleet.ActivateHTTPEndpoints()
+	fleet.Wait()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	goomo2 "iteragit.iteratec.de/go_loomo_go/goomo"
 	"log"
 )
@@ -16,7 +17,7 @@ func main() {
 	if err != nil {
 		log.Fatal("connecting to Loomo: ", err)
 	}
-	err = lc.Start()
+	err = lc.Start(context.Background())
 	if err != nil {
 		log.Fatal("starting Command loop: ", err)
 	}
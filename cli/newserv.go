@@ -6,8 +6,8 @@ import (
 
 func main() {
 	g := goomo.NewGoomo()
-	//g.Start()
+	//g.Start(goomo.InterruptContext())
 	//g.ActivateHTTPEndpoints()
 	g.TestSlam()
-	g.Wait()
+	//g.Wait()
 }
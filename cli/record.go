@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"iteragit.iteratec.de/go_loomo_go/goomo"
+	"log"
+)
+
+// record.go serves the Loomo like newserv.go and records the raw frames of all streams
+// and the sent commands until it is interrupted, e.g. `go run record.go -o run1.session -ai postits`.
+// The stop commands of the shutdown are part of the session.
+func main() {
+	out := flag.String("o", "goomo.session", "session file")
+	ai := flag.String("ai", "", "AI to activate: postits or trafficsigns")
+	flag.Parse()
+
+	g := goomo.NewGoomo()
+	recorder, err := g.RecordSession(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g.Start(goomo.InterruptContext())
+	g.ActivateHTTPEndpoints()
+	switch *ai {
+	case "postits":
+		err = g.Modules().Activate(goomo.PostitAIModule)
+	case "trafficsigns":
+		err = g.Modules().Activate(goomo.TrafficSignAIModule)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g.Wait()
+	// the recorder was closed on shutdown, Close returns its error
+	err = recorder.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("recorded %+v to %s", recorder.Stats(), *out)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"iteragit.iteratec.de/go_loomo_go/goomo"
+	"log"
+	"time"
+)
+
+// replay.go feeds a recorded session into the modules without a Loomo and prints the commands of the AI
+// next to the recorded ones, e.g. `go run replay.go -speed 4 -ai postits run1.session`.
+// With -http the streams and the debug-screen can be watched during the replay, an interrupt ends it early.
+func main() {
+	speed := flag.Float64("speed", 1, "replay speed, 1 is the original timing and 0 as fast as possible")
+	ai := flag.String("ai", "", "AI to activate: postits or trafficsigns")
+	serve := flag.Bool("http", false, "serve the HTTP endpoints")
+	flag.Parse()
+	if flag.NArg() != 1 || *speed < 0 {
+		log.Fatal("usage: replay [-speed factor] [-ai postits|trafficsigns] [-http] session")
+	}
+
+	g := goomo.NewGoomo()
+	if *serve {
+		g.ActivateHTTPEndpoints()
+	}
+	var err error
+	switch *ai {
+	case "postits":
+		err = g.Modules().Activate(goomo.PostitAIModule)
+	case "trafficsigns":
+		err = g.Modules().Activate(goomo.TrafficSignAIModule)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := goomo.InterruptContext()
+	replayer := goomo.NewSessionReplayer(flag.Arg(0), *speed)
+	replayer.Recorded = make(chan goomo.SessionEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.ReplaySession(ctx, replayer)
+	}()
+
+	start := time.Now()
+	recorded := replayer.Recorded
+	for recorded != nil {
+		select {
+		case e, ok := <-recorded:
+			if !ok {
+				recorded = nil
+				continue
+			}
+			fmt.Printf("%10v recorded %s %+v\n", e.Offset.Round(time.Millisecond), e.Command.Tag(), e.Command)
+		case cmd := <-g.Commands():
+			offset := time.Since(start)
+			if *speed > 0 {
+				offset = time.Duration(float64(offset) * *speed)
+			}
+			fmt.Printf("%10v replayed %s %+v\n", offset.Round(time.Millisecond), cmd.Tag(), cmd)
+		}
+	}
+	err = <-done
+	if err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
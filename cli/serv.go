@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"iteragit.iteratec.de/go_loomo_go/goomo"
@@ -25,7 +26,10 @@ func main() {
 
 	lc := goomo.NewLoomoCommunicator()
 	lc.RegisterHandler("SCAM", muxer)
-	motion := &goomo.Motion{Lc: lc}
+	config := goomo.DefaultConfig()
+	shaper := goomo.NewMotionShaper(lc, config.MaxLv, config.MaxAv, config.Motion)
+	go shaper.Start(context.Background())
+	motion := &goomo.Motion{Shaper: shaper}
 	streamOpts := &goomo.StreamOpts{Lc: lc}
 
 	// init AI
@@ -58,7 +62,7 @@ func setupComm(lc *goomo.LoomoCommunicator, cmdsReady chan<- bool) {
 	if err != nil {
 		log.Fatal("connecting to Loomo: ", err)
 	}
-	err = lc.Start()
+	err = lc.Start(context.Background())
 	if err != nil {
 		log.Fatal("starting Command loop: ", err)
 	}
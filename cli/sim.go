@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"iteragit.iteratec.de/go_loomo_go/goomo"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// sim.go runs a fake Loomo, e.g. `go run sim.go -frames ../video/test.h264`,
+// so that newserv.go can be started on a laptop without the robot.
+func main() {
+	frames := flag.String("frames", "", "directory of JPG files or a video file to stream as camera")
+	bcAddr := flag.String("broadcast", "255.255.255.255:1336", "address the command port is announced to")
+	port := flag.String("port", ":1337", "TCP port for commands")
+	serial := flag.String("serial", "", "serial announced after the port")
+	fps := flag.Int("fps", 30, "camera frames per second")
+	flag.Parse()
+
+	if *frames == "" || *fps <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	src, err := goomo.NewFrameSource(*frames)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sim := goomo.NewLoomoSimulator(src)
+	sim.BCAddr = *bcAddr
+	sim.Port = *port
+	sim.Serial = *serial
+	sim.FrameInterval = time.Second / time.Duration(*fps)
+	sim.Received = make(chan goomo.Command)
+	err = sim.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sim.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	for {
+		select {
+		case cmd := <-sim.Received:
+			log.Printf("received %T: %+v", cmd, cmd)
+		case <-interrupt:
+			return
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"image"
 	"image/color"
 	"log"
+	"time"
 )
 
 type Feature struct {
@@ -33,6 +34,11 @@ type ColorTracker struct {
 	Inbound      chan *ManagedMat
 	Outbound     chan [][]Feature
 	Descriptions []HSVDescription
+	// name labels the metrics of the tracker
+	name string
+	// watchdog receives a beat of WatchFeatures after every frame, it is optional
+	watchdog *Watchdog
+	metrics  *moduleMetrics
 }
 
 type PostitTracker struct {
@@ -42,6 +48,12 @@ type PostitTracker struct {
 type TrafficSignTracker struct {
 	Inbound  chan *ManagedMat
 	Outbound chan *TrafficSignFeature
+	// Descriptions and ModelDir default to NewTrafficSignDescription and ./traffic_sign_nn
+	Descriptions []HSVDescription
+	ModelDir     string
+	// Distances locate the signs, it defaults to the SharedDistanceLookup
+	Distances *DistanceLookup
+	metrics   *moduleMetrics
 }
 
 func (tst TrafficSignTracker) StartTrafficSignTracker() {
@@ -50,17 +62,33 @@ func (tst TrafficSignTracker) StartTrafficSignTracker() {
 	ct := ColorTracker{
 		Inbound:      make(chan *ManagedMat),
 		Outbound:     make(chan [][]Feature),
-		Descriptions: NewTrafficSignDescription(),
+		Descriptions: tst.Descriptions,
+		name:         "trafficsign-colors",
+		metrics:      tst.metrics,
+	}
+	if ct.Descriptions == nil {
+		ct.Descriptions = NewTrafficSignDescription()
 	}
 	go ct.StartColorTracker()
 
-	nn, err := NewTrafficSignNN()
+	modelDir := tst.ModelDir
+	if modelDir == "" {
+		modelDir = defaultTrafficSignNN
+	}
+	distances := tst.Distances
+	if distances == nil {
+		distances = SharedDistanceLookup()
+	}
+	nn, err := NewTrafficSignNNFrom(modelDir)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	nn.metrics = tst.metrics
+	metrics := tst.metrics.get()
 
 	for mat := range tst.Inbound {
+		start := time.Now()
 		mat.Assign()
 		ct.Inbound <- mat
 		features := <-ct.Outbound
@@ -82,7 +110,7 @@ func (tst TrafficSignTracker) StartTrafficSignTracker() {
 
 						if certainty > 0.75 && trafficsign.Name != unknownSign {
 
-							dx, dy := SharedDistanceLookup().Distance(feature.imagePos.X, feature.imagePos.Y)
+							dx, dy := distances.Distance(feature.imagePos.X, feature.imagePos.Y)
 							feature.realPos = vg.Point{vg.Length(dx), vg.Length(dy)}
 
 							tsf := TrafficSignFeature{
@@ -90,11 +118,12 @@ func (tst TrafficSignTracker) StartTrafficSignTracker() {
 								Name:    trafficsign.Name,
 							}
 
-							mat.put(func(mat *gocv.Mat) {
+							mat.put(LayerTrafficSigns, func(mat *gocv.Mat) {
 								point := feature.imageBounds.Min
 								point.Y -= 10
 								gocv.PutText(mat, tsf.Name, point, 0, 0.5, red, 2)
 							})
+							metrics.trackerFeatures.With("trafficsigns").Inc()
 							tst.Outbound <- &tsf
 						}
 					}
@@ -108,6 +137,7 @@ func (tst TrafficSignTracker) StartTrafficSignTracker() {
 			}
 		}
 		mat.Done()
+		metrics.trackerSeconds.With("trafficsigns").Since(start)
 	}
 	close(ct.Inbound)
 	nn.Close()
@@ -116,6 +146,7 @@ func (tst TrafficSignTracker) StartTrafficSignTracker() {
 
 func (pt PostitTracker) StartPostitTracker() {
 	logger.Debug("PostitTracker started.")
+	pt.name = "postits"
 	pt.StartColorTracker()
 	logger.Debug("PostitTracker stopped.")
 }
@@ -124,6 +155,9 @@ func (ct ColorTracker) StartColorTracker() {
 	if ct.Descriptions == nil {
 		ct.Descriptions = NewColorTracker()
 	}
+	if ct.name == "" {
+		ct.name = "colors"
+	}
 	inbounds := make([]chan *ManagedMat, len(ct.Descriptions))
 	outbounds := make([]chan []Feature, len(ct.Descriptions))
 	for i, description := range ct.Descriptions {
@@ -131,7 +165,9 @@ func (ct ColorTracker) StartColorTracker() {
 		outbounds[i] = make(chan []Feature)
 		go description.findColorFeature(inbounds[i], outbounds[i])
 	}
+	metrics := ct.metrics.get()
 	for mat := range ct.Inbound {
+		start := time.Now()
 		colorGroups := make([][]Feature, len(ct.Descriptions))
 		for i := range ct.Descriptions {
 			mat.Assign()
@@ -139,9 +175,12 @@ func (ct ColorTracker) StartColorTracker() {
 		}
 		for i := range ct.Descriptions {
 			colorGroups[i] = <-outbounds[i]
+			metrics.trackerFeatures.With(ct.name).Add(uint64(len(colorGroups[i])))
 		}
+		metrics.trackerSeconds.With(ct.name).Since(start)
 		// TODO: Send on closed channel, when activating / deactivating
 		ct.Outbound <- colorGroups
+		ct.watchdog.Beat(WatchFeatures)
 		mat.Done()
 	}
 	for i := range ct.Descriptions {
@@ -6,16 +6,18 @@ import (
 	"log"
 )
 
+// HSV is a color with hue in degrees and saturation and value in percent
 type HSV struct {
-	H float64
-	S float64
-	V float64
+	H float64 `json:"h"`
+	S float64 `json:"s"`
+	V float64 `json:"v"`
 }
 
+// HSVB are the tolerated deviations from a HSV color
 type HSVB struct {
-	HB float64
-	SB float64
-	VB float64
+	HB float64 `json:"hb"`
+	SB float64 `json:"sb"`
+	VB float64 `json:"vb"`
 }
 
 type HSVDescription struct {
@@ -86,13 +88,16 @@ func (description HSVDescription) findColorFeature(inbound chan *ManagedMat, out
 		if err != nil {
 			log.Fatal(err)
 		}
-		managedMat.put(func(mat *gocv.Mat) {
+		// the functions are applied in reverse, so the features are drawn above the contours
+		managedMat.put(LayerFeatures, func(mat *gocv.Mat) {
+			for _, feature := range features {
+				gocv.Rectangle(mat, feature.imageBounds, green, 1)
+				gocv.ArrowedLine(mat, image.Point{mat.Cols() / 2, mat.Rows()}, feature.imagePos, red, 1)
+			}
+		})
+		managedMat.put(LayerContours, func(mat *gocv.Mat) {
 			if len(contours) != 0 {
 				gocv.FillPoly(mat, contours, white)
-				for _, feature := range features {
-					gocv.Rectangle(mat, feature.imageBounds, green, 1)
-					gocv.ArrowedLine(mat, image.Point{mat.Cols() / 2, mat.Rows()}, feature.imagePos, red, 1)
-				}
 			}
 		})
 		managedMat.Done()
@@ -15,6 +15,7 @@ import (
 	"gocv.io/x/gocv"
 	"math"
 	"sort"
+	"time"
 	"unsafe"
 )
 
@@ -26,6 +27,8 @@ type MonoSLAM struct {
 
 	numberOfPoses int
 	currentState  TrackingState
+	telemetry     *TelemetryHub
+	metrics       *moduleMetrics
 
 	c *C.MonoSLAM
 }
@@ -45,9 +48,12 @@ func NewMonoSLAM(orbvocPath string, settingsPath string, showViewer, semiDense b
 func (m *MonoSLAM) StartSlam(Inbound chan *ManagedMat) {
 	logger.Debug("Slam started.")
 	m.Inbound = Inbound
+	metrics := m.metrics.get()
 
 	for managedMat := range m.Inbound {
+		start := time.Now()
 		m.Track(managedMat.mat, managedMat.timestamp)
+		metrics.slamSeconds.With().Since(start)
 
 		//if m.PoseDidChange() {
 		//	pose, err := m.GetLastPose()
@@ -58,10 +64,17 @@ func (m *MonoSLAM) StartSlam(Inbound chan *ManagedMat) {
 		//}
 
 		state := m.GetState()
-		if state != m.currentState {
+		metrics.slamFrames.With(state.String()).Inc()
+		stateChanged := state != m.currentState
+		if stateChanged {
 			m.currentState = state
 			//fmt.Println("state", state)
 		}
+		if m.telemetry != nil && (stateChanged || m.PoseDidChange()) {
+			pose, _ := m.GetLastPose()
+			x, y, z := PoseToPosition(pose)
+			m.telemetry.Publish(TopicSlam, SlamTelemetry{state, x, y, z})
+		}
 
 		managedMat.Done()
 	}
@@ -274,6 +287,22 @@ const (
 	Lost
 )
 
+var trackingStateNames = map[TrackingState]string{
+	SystemNotReady: "system-not-ready",
+	NoImagesYet:    "no-images-yet",
+	NotInitialized: "not-initialized",
+	Ok:             "ok",
+	Lost:           "lost",
+}
+
+func (s TrackingState) String() string {
+	return trackingStateNames[s]
+}
+
+func (s TrackingState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 func (m *MonoSLAM) GetState() TrackingState {
 	i := int(C.GetState(m.c))
 	state := TrackingState(i)
@@ -9,6 +9,7 @@ import (
 	"image"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var counter = 0
@@ -27,6 +28,7 @@ type TrafficSignNN struct {
 	imagesPlaceholder tf.Output
 	keepProb          tf.Output
 	keepProbConv      tf.Output
+	metrics           *moduleMetrics
 }
 
 type TrafficSign struct {
@@ -42,20 +44,35 @@ const (
 	unknownSign    = "unknown"
 )
 
+const defaultTrafficSignNN = "traffic_sign_nn/"
+
 // loads traffic sign neural net from ./traffic_sign_nn
 func NewTrafficSignNN() (*TrafficSignNN, error) {
-	return newTrafficSignNN()
+	return newTrafficSignNN(defaultTrafficSignNN)
+}
+
+// loads traffic sign neural net from the saved model in exportDir
+func NewTrafficSignNNFrom(exportDir string) (*TrafficSignNN, error) {
+	return newTrafficSignNN(exportDir)
 }
 
 // image has to be 32 x 32 px and grayscaled with values ranging from 0 to 255
 // returns a prediction for a traffic sign and its certainy
 func (nn *TrafficSignNN) PredictWithCertainty(image *gocv.Mat) (TrafficSign, float32, error) {
-	return nn.predictWithCertainty(image)
+	metrics := nn.metrics.get()
+	start := time.Now()
+	sign, certainty, err := nn.predictWithCertainty(image)
+	metrics.nnSeconds.With().Since(start)
+	if err != nil {
+		metrics.nnPredictions.With("error").Inc()
+	} else {
+		metrics.nnPredictions.With(sign.Name).Inc()
+	}
+	return sign, certainty, err
 }
 
-func newTrafficSignNN() (*TrafficSignNN, error) {
+func newTrafficSignNN(exportDir string) (*TrafficSignNN, error) {
 	//exportDir := "/home/markus/PycharmProjects/simple_traffic_sign_detection/traffic_sign_nn"
-	exportDir := "traffic_sign_nn/"
 	model, err := tf.LoadSavedModel(exportDir, []string{"serve"}, nil)
 	if err != nil {
 		return nil, err
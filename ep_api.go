@@ -0,0 +1,281 @@
+package goomo
+
+//ep_api.go serves the versioned REST API below /api/v1, the legacy endpoints stay unchanged
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const apiPrefix = "/api/v1"
+
+// APIError is the body of every error response of /api/v1
+type APIError struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type API struct {
+	g *Goomo
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	responseJSON, err := json.Marshal(v)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	responseJSON, _ := json.Marshal(APIError{
+		Status:  status,
+		Error:   http.StatusText(status),
+		Message: message,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+// writeAPICommandError answers with the status of a failed command, it returns false if err is nil
+func writeAPICommandError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	logger.Error(err)
+	writeAPIError(w, commandStatus(err), err.Error())
+	return true
+}
+
+// decodeBody rejects missing bodies, unknown fields and trailing data
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil || r.ContentLength == 0 {
+		writeAPIError(w, http.StatusBadRequest, "Please send a request body")
+		return false
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = fmt.Errorf("unexpected data after the JSON document")
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func (g *Goomo) newAPIRouter(r *mux.Router, stream, teleop http.Handler) {
+	api := &API{g: g}
+	s := r.PathPrefix(apiPrefix).Subrouter()
+	s.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "no endpoint "+r.URL.Path)
+	})
+	s.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path)
+	})
+
+	drive := func(h http.HandlerFunc) http.Handler { return g.access.guard(h, true) }
+	mutate := func(h http.HandlerFunc) http.Handler { return g.access.guard(h, false) }
+
+	s.HandleFunc("/openapi.json", api.openAPI).Methods(http.MethodGet)
+	s.HandleFunc("/connection", api.connection).Methods(http.MethodGet)
+	s.HandleFunc("/queue", api.queue).Methods(http.MethodGet)
+	s.Handle("/metrics", g.metrics.registry).Methods(http.MethodGet)
+	s.Handle("/ws/telemetry", g.telemetry).Methods(http.MethodGet)
+	s.Handle("/ws/teleop", teleop).Methods(http.MethodGet)
+	s.Handle("/lease", g.access).Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+	s.Handle("/motion", drive(api.motion)).Methods(http.MethodPut)
+	s.Handle("/head", drive(api.head)).Methods(http.MethodPut)
+	s.Handle("/stop", mutate(api.stop)).Methods(http.MethodPost)
+	s.Handle("/command", drive(api.command)).Methods(http.MethodPost)
+	s.Handle("/stream", stream).Methods(http.MethodGet)
+	s.Handle("/stream/debug", g.screen).Methods(http.MethodGet)
+	s.HandleFunc("/snapshot.jpg", g.screen.Snapshot).Methods(http.MethodGet)
+	s.Handle("/stream/{option}", mutate(api.streamOption)).Methods(http.MethodPut)
+	s.HandleFunc("/sensors", api.sensors).Methods(http.MethodGet)
+	s.HandleFunc("/sensors/{stream}", api.sensor).Methods(http.MethodGet)
+	s.Handle("/sensors/{stream}/{option}", mutate(api.sensorOption)).Methods(http.MethodPut)
+	s.Handle("/settings", drive(api.settings)).Methods(http.MethodGet, http.MethodPut)
+	s.HandleFunc("/modules", api.modules).Methods(http.MethodGet)
+	s.Handle("/watchdog", g.watchdog).Methods(http.MethodGet)
+	s.Handle("/watchdog/reset", drive(g.watchdog.reset)).Methods(http.MethodPost)
+	s.Handle("/video", &DownloadVideo{g: g}).Methods(http.MethodGet)
+	recordings := &Recordings{g: g}
+	s.Handle("/recordings", mutate(recordings.ServeHTTP)).Methods(http.MethodGet, http.MethodPost)
+	s.Handle("/recordings/{id}", mutate(recordings.serveRecording)).Methods(http.MethodGet, http.MethodDelete)
+	s.Handle("/recordings/{id}/stop", mutate(recordings.stop)).Methods(http.MethodPost)
+	s.HandleFunc("/recordings/{id}/video", recordings.video).Methods(http.MethodGet, http.MethodHead)
+}
+
+func (a *API) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPIDocument))
+}
+
+func (a *API) connection(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.g.lc.ConnectionStatus())
+}
+
+func (a *API) queue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.g.lc.QueueStats())
+}
+
+func (a *API) execute(w http.ResponseWriter, cmd Command) {
+	if writeAPICommandError(w, a.g.lc.ExecuteCommand(cmd)) {
+		return
+	}
+	writeJSON(w, http.StatusOK, CommandOutcome{Tag: cmd.Tag().String(), Sent: true})
+}
+
+func (a *API) motion(w http.ResponseWriter, r *http.Request) {
+	var mr MotionRequest
+	if !decodeBody(w, r, &mr) {
+		return
+	}
+	_, err := mr.Command()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cmd, err := mr.Shape(a.g.shaper)
+	if writeAPICommandError(w, err) {
+		return
+	}
+	// the shaper sends the ramped velocity later, so nothing was sent yet
+	writeJSON(w, http.StatusAccepted, CommandOutcome{Tag: cmd.Tag().String(), Accepted: true})
+}
+
+func (a *API) head(w http.ResponseWriter, r *http.Request) {
+	var hr HeadRequest
+	if !decodeBody(w, r, &hr) {
+		return
+	}
+	cmd, err := hr.Command()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.execute(w, cmd)
+}
+
+func (a *API) stop(w http.ResponseWriter, r *http.Request) {
+	if writeAPICommandError(w, a.g.shaper.Stop()) {
+		return
+	}
+	writeJSON(w, http.StatusOK, CommandOutcome{Sent: true})
+}
+
+func (a *API) command(w http.ResponseWriter, r *http.Request) {
+	var cr CommandRequest
+	if !decodeBody(w, r, &cr) {
+		return
+	}
+	cmd, err := cr.Command()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c, ok := cmd.(*CSSTCommand); ok && !a.g.lc.hasHandler(c.Stream) {
+		writeAPIError(w, http.StatusBadRequest, "no handler registered for stream "+c.Stream)
+		return
+	}
+	a.execute(w, cmd)
+}
+
+func (a *API) streamOption(w http.ResponseWriter, r *http.Request) {
+	a.switchSensor(w, CameraStream, mux.Vars(r)["option"])
+}
+
+func (a *API) sensorStatus(stream string) (SensorStatus, bool) {
+	port, ok := a.g.sensors.Port(stream)
+	if !ok {
+		return SensorStatus{}, false
+	}
+	return SensorStatus{
+		Stream: stream,
+		Port:   port,
+		Active: a.g.sensors.IsActive(stream),
+	}, true
+}
+
+func (a *API) sensors(w http.ResponseWriter, r *http.Request) {
+	response := make([]SensorStatus, 0)
+	for _, stream := range a.g.sensors.Streams() {
+		status, _ := a.sensorStatus(stream)
+		response = append(response, status)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *API) sensor(w http.ResponseWriter, r *http.Request) {
+	stream := strings.ToUpper(mux.Vars(r)["stream"])
+	status, ok := a.sensorStatus(stream)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown stream "+stream)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *API) sensorOption(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	a.switchSensor(w, strings.ToUpper(vars["stream"]), vars["option"])
+}
+
+// switchSensor starts or stops the stream and responds with its SensorStatus
+func (a *API) switchSensor(w http.ResponseWriter, stream, option string) {
+	if _, ok := a.g.sensors.Port(stream); !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown stream "+stream)
+		return
+	}
+	var err error
+	switch option {
+	case "start":
+		err = a.g.sensors.Start(stream)
+	case "stop":
+		err = a.g.sensors.Stop(stream)
+	default:
+		writeAPIError(w, http.StatusBadRequest, "option has to be \"start\" or \"stop\"")
+		return
+	}
+	if writeAPICommandError(w, err) {
+		return
+	}
+	status, _ := a.sensorStatus(stream)
+	writeJSON(w, http.StatusOK, status)
+}
+
+// settings returns the state of every module, after switching the modules of the body for PUT
+func (a *API) settings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var update map[string]bool
+		if !decodeBody(w, r, &update) {
+			return
+		}
+		_, err := a.g.modules.Apply(update)
+		if err != nil {
+			status := moduleStatus(err)
+			if status == http.StatusInternalServerError {
+				logger.Error(err)
+			}
+			writeAPIError(w, status, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, a.g.modules.States())
+}
+
+func (a *API) modules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.g.modules.List())
+}
@@ -0,0 +1,21 @@
+package goomo
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type Connection struct {
+	Lc *LoomoCommunicator
+}
+
+func (c *Connection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	responseJSON, err := json.Marshal(c.Lc.ConnectionStatus())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
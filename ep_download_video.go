@@ -1,23 +1,24 @@
 package goomo
 
 import (
-	"io/ioutil"
 	"net/http"
 )
 
+// DownloadVideo serves the latest recording
 type DownloadVideo struct {
+	g *Goomo
 }
 
 func (s *DownloadVideo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	video, err := ioutil.ReadFile(videofilePath)
+	l, err := s.g.Recordings()
 	if err != nil {
-		logger.Error(err)
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return
 	}
-	w.Header().Set("Content-Disposition", "attachment; filename=loomo_video.h264")
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	_, err = w.Write(video)
+	recording, err := l.Latest()
 	if err != nil {
-		logger.Error(err)
+		writeAPIError(w, recordingStatus(err), err.Error())
+		return
 	}
+	serveRecordingVideo(w, r, l, recording)
 }
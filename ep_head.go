@@ -0,0 +1,49 @@
+package goomo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type Head struct {
+	Lc *LoomoCommunicator
+}
+
+// HeadRequest moves the head to Pitch and Yaw in rad for mode "angle"
+// or turns it with Pitch and Yaw in rad/s for mode "rate"
+type HeadRequest struct {
+	Mode  string  `json:"mode"`
+	Pitch float32 `json:"pitch"`
+	Yaw   float32 `json:"yaw"`
+}
+
+func (hr HeadRequest) Command() (Command, error) {
+	switch hr.Mode {
+	case "angle":
+		return &CMHDCommand{Mode: HeadAngle, Vert: hr.Pitch, Hori: hr.Yaw}, nil
+	case "rate":
+		return &CMHDCommand{Mode: HeadRate, Vert: hr.Pitch, Hori: hr.Yaw}, nil
+	}
+	return nil, errors.New("mode has to be \"angle\" or \"rate\"")
+}
+
+func (h *Head) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var hr HeadRequest
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&hr)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	cmd, err := hr.Command()
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	writeCommandError(w, h.Lc.ExecuteCommand(cmd))
+}
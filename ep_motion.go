@@ -2,17 +2,45 @@ package goomo
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 )
 
+// Motion sets the target of one velocity, which the Shaper ramps towards
 type Motion struct {
-	Lc *LoomoCommunicator
+	Shaper *MotionShaper
 }
 
 type MotionRequest struct {
-	Type  string
-	Value float32
+	Type  string  `json:"type"`
+	Value float32 `json:"value"`
+}
+
+// Command returns a CLVLCommand for type "linear" and a CAVLCommand for type "angular"
+func (mr MotionRequest) Command() (Command, error) {
+	switch mr.Type {
+	case "linear":
+		return &CLVLCommand{Lv: mr.Value}, nil
+	case "angular":
+		return &CAVLCommand{Av: mr.Value}, nil
+	}
+	return nil, fmt.Errorf("type has to be \"linear\" or \"angular\", not %q", mr.Type)
+}
+
+// Shape sets the velocity of the command as target of s and returns the command with the clamped velocity
+func (mr MotionRequest) Shape(s *MotionShaper) (Command, error) {
+	cmd, err := mr.Command()
+	if err != nil {
+		return nil, err
+	}
+	switch c := cmd.(type) {
+	case *CLVLCommand:
+		c.Lv, err = s.SetLinear(c.Lv)
+	case *CAVLCommand:
+		c.Av, err = s.SetAngular(c.Av)
+	}
+	return cmd, err
 }
 
 func (m *Motion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -28,18 +56,12 @@ func (m *Motion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch mr.Type {
-	case "linear":
-		log.Printf("linear: %v", mr.Value)
-		err := m.Lc.ExecuteCommand(&CLVLCommand{Lv: mr.Value})
-		if err != nil {
-			logger.Error(err)
-		}
-	case "angular":
-		log.Printf("angular: %v", mr.Value)
-		err := m.Lc.ExecuteCommand(&CAVLCommand{Av: mr.Value})
-		if err != nil {
-			logger.Error(err)
-		}
+	_, err = mr.Command()
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
 	}
+	log.Printf("%s: %v", mr.Type, mr.Value)
+	_, err = mr.Shape(m.Shaper)
+	writeCommandError(w, err)
 }
@@ -0,0 +1,1867 @@
+package goomo
+
+// openAPIDocument describes the endpoints below /api/v1, it is served at /api/v1/openapi.json
+const openAPIDocument = `{
+	"openapi": "3.0.2",
+	"info": {
+		"title": "goomo",
+		"version": "1.0.0",
+		"description": "Controls a Segway Loomo. All errors are returned as APIError."
+	},
+	"servers": [
+		{
+			"url": "/api/v1"
+		}
+	],
+	"paths": {
+		"/lease": {
+			"get": {
+				"summary": "State of the control lease",
+				"responses": {
+					"200": {
+						"description": "Lease",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/LeaseStatus"
+								}
+							}
+						}
+					}
+				}
+			},
+			"post": {
+				"summary": "Acquire or renew the control lease",
+				"responses": {
+					"200": {
+						"description": "Lease",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/LeaseStatus"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			},
+			"delete": {
+				"summary": "Release the control lease",
+				"responses": {
+					"200": {
+						"description": "Lease",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/LeaseStatus"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/connection": {
+			"get": {
+				"summary": "State of the connection to the Loomo",
+				"responses": {
+					"200": {
+						"description": "Last connection event",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/ConnectionEvent"
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/ws/telemetry": {
+			"get": {
+				"summary": "WebSocket of TelemetryMessages, see README",
+				"responses": {
+					"101": {
+						"description": "Switching to the WebSocket protocol"
+					}
+				},
+				"parameters": [
+					{
+						"name": "topics",
+						"in": "query",
+						"required": false,
+						"description": "comma separated topics, default all",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/ws/teleop": {
+			"get": {
+				"summary": "WebSocket for driving with TwistMessages, see README",
+				"responses": {
+					"101": {
+						"description": "Switching to the WebSocket protocol"
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "access_token",
+						"in": "query",
+						"required": false,
+						"description": "token if tokens are configured",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/metrics": {
+			"get": {
+				"summary": "Metrics of the modules of this robot in the Prometheus text format, the fleet labels them with the robot id",
+				"responses": {
+					"200": {
+						"description": "Prometheus text format 0.0.4",
+						"content": {
+							"text/plain": {
+								"schema": {
+									"type": "string"
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/queue": {
+			"get": {
+				"summary": "State of the command queue",
+				"responses": {
+					"200": {
+						"description": "Queue statistics",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/QueueStats"
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/motion": {
+			"put": {
+				"summary": "Set the target of the linear or angular velocity, which is clamped to the limits and ramped towards",
+				"responses": {
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"502": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"504": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"202": {
+						"description": "Target was accepted, the MotionShaper sends the ramped velocity",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/CommandOutcome"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"$ref": "#/components/schemas/MotionRequest"
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/head": {
+			"put": {
+				"summary": "Turn the head",
+				"responses": {
+					"200": {
+						"description": "Command was written to the Loomo",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/CommandOutcome"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"502": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"504": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"$ref": "#/components/schemas/HeadRequest"
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/stop": {
+			"post": {
+				"summary": "Set both velocities to 0 ahead of all queued commands without ramping",
+				"responses": {
+					"200": {
+						"description": "Command was written to the Loomo",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/CommandOutcome"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"504": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/command": {
+			"post": {
+				"summary": "Send any command",
+				"responses": {
+					"200": {
+						"description": "Command was written to the Loomo",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/CommandOutcome"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"502": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"504": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"$ref": "#/components/schemas/CommandRequest"
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/stream": {
+			"get": {
+				"summary": "MJPEG stream of the camera",
+				"responses": {
+					"200": {
+						"description": "multipart/x-mixed-replace stream of JPG images",
+						"content": {
+							"multipart/x-mixed-replace": {
+								"schema": {
+									"type": "string",
+									"format": "binary"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "fps",
+						"in": "query",
+						"required": false,
+						"description": "maximum frame rate, 1-30",
+						"schema": {
+							"type": "integer"
+						}
+					},
+					{
+						"name": "width",
+						"in": "query",
+						"required": false,
+						"description": "width in pixels, 16-1920, the aspect ratio is kept",
+						"schema": {
+							"type": "integer"
+						}
+					},
+					{
+						"name": "quality",
+						"in": "query",
+						"required": false,
+						"description": "JPG quality, 1-100",
+						"schema": {
+							"type": "integer"
+						}
+					},
+					{
+						"name": "gray",
+						"in": "query",
+						"required": false,
+						"description": "gray scale frames",
+						"schema": {
+							"type": "boolean"
+						}
+					}
+				]
+			}
+		},
+		"/stream/{option}": {
+			"put": {
+				"summary": "Start or stop the camera stream",
+				"responses": {
+					"200": {
+						"description": "State of the stream",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/SensorStatus"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"502": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"504": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "option",
+						"in": "path",
+						"required": true,
+						"description": "start or stop",
+						"schema": {
+							"type": "string",
+							"enum": [
+								"start",
+								"stop"
+							]
+						}
+					}
+				],
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/stream/debug": {
+			"get": {
+				"summary": "MJPEG stream of the camera with the drawings of the modules, while the debug-screen is active",
+				"responses": {
+					"200": {
+						"description": "multipart/x-mixed-replace stream of JPG images",
+						"content": {
+							"multipart/x-mixed-replace": {
+								"schema": {
+									"type": "string",
+									"format": "binary"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "layers",
+						"in": "query",
+						"required": false,
+						"description": "comma separated layers out of contours, features and trafficsigns, default all",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/snapshot.jpg": {
+			"get": {
+				"summary": "Last camera frame, raw or annotated",
+				"responses": {
+					"200": {
+						"description": "JPG image",
+						"content": {
+							"image/jpeg": {
+								"schema": {
+									"type": "string",
+									"format": "binary"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "annotated",
+						"in": "query",
+						"required": false,
+						"description": "draw the layers, default false",
+						"schema": {
+							"type": "boolean"
+						}
+					},
+					{
+						"name": "layers",
+						"in": "query",
+						"required": false,
+						"description": "comma separated layers out of contours, features and trafficsigns, implies annotated, default all",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/sensors": {
+			"get": {
+				"summary": "List all sensor streams",
+				"responses": {
+					"200": {
+						"description": "All streams",
+						"content": {
+							"application/json": {
+								"schema": {
+									"type": "array",
+									"items": {
+										"$ref": "#/components/schemas/SensorStatus"
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/sensors/{stream}": {
+			"get": {
+				"summary": "State of one sensor stream",
+				"responses": {
+					"200": {
+						"description": "State of the stream",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/SensorStatus"
+								}
+							}
+						}
+					},
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "stream",
+						"in": "path",
+						"required": true,
+						"description": "CAM, IMU, ODO, DEP or ULS",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/sensors/{stream}/{option}": {
+			"put": {
+				"summary": "Start or stop a sensor stream",
+				"responses": {
+					"200": {
+						"description": "State of the stream",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/SensorStatus"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"502": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"503": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"504": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "stream",
+						"in": "path",
+						"required": true,
+						"description": "CAM, IMU, ODO, DEP or ULS",
+						"schema": {
+							"type": "string"
+						}
+					},
+					{
+						"name": "option",
+						"in": "path",
+						"required": true,
+						"description": "start or stop",
+						"schema": {
+							"type": "string",
+							"enum": [
+								"start",
+								"stop"
+							]
+						}
+					}
+				],
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/settings": {
+			"get": {
+				"summary": "State of every module of /modules",
+				"responses": {
+					"200": {
+						"description": "Settings",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/Settings"
+								}
+							}
+						}
+					}
+				}
+			},
+			"put": {
+				"summary": "Switch modules, missing ones are left unchanged. Unknown modules are rejected with 400, violated dependencies or conflicts with 409",
+				"responses": {
+					"200": {
+						"description": "Settings after the update",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/Settings"
+								}
+							}
+						}
+					},
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"500": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"$ref": "#/components/schemas/Settings"
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/modules": {
+			"get": {
+				"summary": "List the modules in the order they are activated with their dependencies and conflicts",
+				"responses": {
+					"200": {
+						"description": "All modules",
+						"content": {
+							"application/json": {
+								"schema": {
+									"type": "array",
+									"items": {
+										"$ref": "#/components/schemas/ModuleInfo"
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/watchdog": {
+			"get": {
+				"summary": "State of the watchdog which stops the Loomo when a source of the AIs stalls",
+				"responses": {
+					"200": {
+						"description": "Watchdog",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/WatchdogStatus"
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/watchdog/reset": {
+			"post": {
+				"summary": "Clear the latched fault so the AIs drive again, rejected with 409 while a watched source is stale",
+				"responses": {
+					"200": {
+						"description": "Watchdog after the reset",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/WatchdogStatus"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/recordings": {
+			"get": {
+				"summary": "List the recordings with the used disk space and the quota",
+				"responses": {
+					"200": {
+						"description": "Recordings",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/RecordingList"
+								}
+							}
+						}
+					}
+				}
+			},
+			"post": {
+				"summary": "Start a recording",
+				"responses": {
+					"400": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"507": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"201": {
+						"description": "Started recording",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/Recording"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"requestBody": {
+					"required": false,
+					"content": {
+						"application/json": {
+							"schema": {
+								"$ref": "#/components/schemas/RecordingRequest"
+							}
+						}
+					}
+				},
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/recordings/{id}": {
+			"get": {
+				"summary": "One recording",
+				"responses": {
+					"200": {
+						"description": "Recording",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/Recording"
+								}
+							}
+						}
+					},
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "id",
+						"in": "path",
+						"required": true,
+						"description": "id of the recording",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			},
+			"delete": {
+				"summary": "Delete a stopped recording and its video",
+				"responses": {
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"204": {
+						"description": "Deleted"
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "id",
+						"in": "path",
+						"required": true,
+						"description": "id of the recording",
+						"schema": {
+							"type": "string"
+						}
+					}
+				],
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/recordings/{id}/stop": {
+			"post": {
+				"summary": "Stop the running recording",
+				"responses": {
+					"200": {
+						"description": "Stopped recording",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/Recording"
+								}
+							}
+						}
+					},
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"409": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					},
+					"401": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "id",
+						"in": "path",
+						"required": true,
+						"description": "id of the recording",
+						"schema": {
+							"type": "string"
+						}
+					}
+				],
+				"security": [
+					{
+						"bearer": []
+					}
+				]
+			}
+		},
+		"/recordings/{id}/video": {
+			"get": {
+				"summary": "Download the video of a recording, range requests are supported",
+				"responses": {
+					"200": {
+						"description": "h264 video",
+						"content": {
+							"application/octet-stream": {
+								"schema": {
+									"type": "string",
+									"format": "binary"
+								}
+							}
+						}
+					},
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				},
+				"parameters": [
+					{
+						"name": "id",
+						"in": "path",
+						"required": true,
+						"description": "id of the recording",
+						"schema": {
+							"type": "string"
+						}
+					}
+				]
+			}
+		},
+		"/video": {
+			"get": {
+				"summary": "Download the video of the latest recording",
+				"responses": {
+					"200": {
+						"description": "h264 video",
+						"content": {
+							"application/octet-stream": {
+								"schema": {
+									"type": "string",
+									"format": "binary"
+								}
+							}
+						}
+					},
+					"404": {
+						"description": "Error",
+						"content": {
+							"application/json": {
+								"schema": {
+									"$ref": "#/components/schemas/APIError"
+								}
+							}
+						}
+					}
+				}
+			}
+		},
+		"/openapi.json": {
+			"get": {
+				"summary": "This document",
+				"responses": {
+					"200": {
+						"description": "OpenAPI document",
+						"content": {
+							"application/json": {
+								"schema": {
+									"type": "object"
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	},
+	"components": {
+		"securitySchemes": {
+			"bearer": {
+				"type": "http",
+				"scheme": "bearer",
+				"description": "Needed for PUT, POST and DELETE if tokens are configured, driving routes also need the control lease (409 otherwise), without tokens it is held by the remote address"
+			}
+		},
+		"schemas": {
+			"LeaseStatus": {
+				"type": "object",
+				"properties": {
+					"held": {
+						"type": "boolean"
+					},
+					"holder": {
+						"type": "string"
+					},
+					"expires": {
+						"type": "string",
+						"format": "date-time"
+					},
+					"yours": {
+						"type": "boolean"
+					}
+				}
+			},
+			"APIError": {
+				"type": "object",
+				"required": [
+					"status",
+					"error",
+					"message"
+				],
+				"properties": {
+					"status": {
+						"type": "integer"
+					},
+					"error": {
+						"type": "string"
+					},
+					"message": {
+						"type": "string"
+					}
+				}
+			},
+			"ConnectionEvent": {
+				"type": "object",
+				"properties": {
+					"state": {
+						"type": "string",
+						"enum": [
+							"disconnected",
+							"connecting",
+							"connected",
+							"lost"
+						]
+					},
+					"addr": {
+						"type": "string"
+					},
+					"attempts": {
+						"type": "integer"
+					},
+					"error": {
+						"type": "string"
+					},
+					"time": {
+						"type": "string",
+						"format": "date-time"
+					}
+				}
+			},
+			"QueueStats": {
+				"type": "object",
+				"properties": {
+					"urgent": {
+						"type": "integer"
+					},
+					"queued": {
+						"type": "integer"
+					},
+					"maxQueued": {
+						"type": "integer"
+					},
+					"sent": {
+						"type": "integer"
+					},
+					"coalesced": {
+						"type": "integer"
+					},
+					"rejected": {
+						"type": "integer"
+					},
+					"failed": {
+						"type": "integer"
+					}
+				}
+			},
+			"MotionRequest": {
+				"type": "object",
+				"required": [
+					"type",
+					"value"
+				],
+				"properties": {
+					"type": {
+						"type": "string",
+						"enum": [
+							"linear",
+							"angular"
+						]
+					},
+					"value": {
+						"type": "number",
+						"format": "float"
+					}
+				}
+			},
+			"HeadRequest": {
+				"type": "object",
+				"required": [
+					"mode",
+					"pitch",
+					"yaw"
+				],
+				"properties": {
+					"mode": {
+						"type": "string",
+						"enum": [
+							"angle",
+							"rate"
+						]
+					},
+					"pitch": {
+						"type": "number",
+						"format": "float"
+					},
+					"yaw": {
+						"type": "number",
+						"format": "float"
+					}
+				}
+			},
+			"CommandRequest": {
+				"type": "object",
+				"required": [
+					"tag"
+				],
+				"description": "Exactly the fields of the tag have to be set",
+				"properties": {
+					"tag": {
+						"type": "string",
+						"enum": [
+							"CSST",
+							"CEST",
+							"CSPS",
+							"CLVL",
+							"CAVL",
+							"CHMD"
+						]
+					},
+					"port": {
+						"type": "string"
+					},
+					"stream": {
+						"type": "string"
+					},
+					"x": {
+						"type": "number",
+						"format": "float"
+					},
+					"y": {
+						"type": "number",
+						"format": "float"
+					},
+					"lv": {
+						"type": "number",
+						"format": "float"
+					},
+					"av": {
+						"type": "number",
+						"format": "float"
+					},
+					"mode": {
+						"type": "string",
+						"enum": [
+							"angle",
+							"rate"
+						]
+					},
+					"pitch": {
+						"type": "number",
+						"format": "float"
+					},
+					"yaw": {
+						"type": "number",
+						"format": "float"
+					}
+				}
+			},
+			"CommandOutcome": {
+				"type": "object",
+				"properties": {
+					"tag": {
+						"type": "string"
+					},
+					"sent": {
+						"type": "boolean"
+					},
+					"accepted": {
+						"type": "boolean"
+					},
+					"error": {
+						"type": "string"
+					}
+				}
+			},
+			"SensorStatus": {
+				"type": "object",
+				"properties": {
+					"stream": {
+						"type": "string"
+					},
+					"port": {
+						"type": "string"
+					},
+					"active": {
+						"type": "boolean"
+					}
+				}
+			},
+			"Recording": {
+				"type": "object",
+				"properties": {
+					"id": {
+						"type": "string"
+					},
+					"name": {
+						"type": "string"
+					},
+					"metadata": {
+						"type": "object",
+						"additionalProperties": {
+							"type": "string"
+						}
+					},
+					"started": {
+						"type": "string",
+						"format": "date-time"
+					},
+					"stopped": {
+						"type": "string",
+						"format": "date-time"
+					},
+					"active": {
+						"type": "boolean"
+					},
+					"size": {
+						"type": "integer",
+						"format": "int64"
+					}
+				}
+			},
+			"RecordingList": {
+				"type": "object",
+				"properties": {
+					"recordings": {
+						"type": "array",
+						"items": {
+							"$ref": "#/components/schemas/Recording"
+						}
+					},
+					"used": {
+						"type": "integer",
+						"format": "int64"
+					},
+					"quota": {
+						"type": "integer",
+						"format": "int64"
+					}
+				}
+			},
+			"RecordingRequest": {
+				"type": "object",
+				"properties": {
+					"name": {
+						"type": "string"
+					},
+					"metadata": {
+						"type": "object",
+						"additionalProperties": {
+							"type": "string"
+						}
+					}
+				}
+			},
+			"Settings": {
+				"type": "object",
+				"description": "Module ids mapped to whether they are active, e.g. debug-screen, postit-ai, trafficsign-ai, slam and video-capture",
+				"additionalProperties": {
+					"type": "boolean"
+				}
+			},
+			"WatchdogFault": {
+				"type": "object",
+				"properties": {
+					"source": {
+						"type": "string",
+						"enum": [
+							"frames",
+							"features",
+							"ai"
+						]
+					},
+					"age": {
+						"type": "string",
+						"example": "1.1s"
+					},
+					"threshold": {
+						"type": "string",
+						"example": "1s"
+					},
+					"time": {
+						"type": "string",
+						"format": "date-time"
+					}
+				}
+			},
+			"WatchdogSource": {
+				"type": "object",
+				"properties": {
+					"name": {
+						"type": "string"
+					},
+					"watched": {
+						"type": "boolean"
+					},
+					"threshold": {
+						"type": "string",
+						"example": "1s"
+					},
+					"age": {
+						"type": "string",
+						"description": "time since the last beat, only while watched",
+						"example": "40ms"
+					}
+				}
+			},
+			"WatchdogStatus": {
+				"type": "object",
+				"properties": {
+					"faulted": {
+						"type": "boolean"
+					},
+					"fault": {
+						"$ref": "#/components/schemas/WatchdogFault"
+					},
+					"sources": {
+						"type": "array",
+						"items": {
+							"$ref": "#/components/schemas/WatchdogSource"
+						}
+					}
+				}
+			},
+			"ModuleInfo": {
+				"type": "object",
+				"properties": {
+					"id": {
+						"type": "string"
+					},
+					"dependencies": {
+						"type": "array",
+						"items": {
+							"type": "string"
+						}
+					},
+					"conflicts": {
+						"type": "array",
+						"items": {
+							"type": "string"
+						}
+					},
+					"active": {
+						"type": "boolean"
+					},
+					"message": {
+						"type": "string"
+					}
+				}
+			}
+		}
+	}
+}
+`
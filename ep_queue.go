@@ -0,0 +1,21 @@
+package goomo
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type CommandQueue struct {
+	Lc *LoomoCommunicator
+}
+
+func (q *CommandQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	responseJSON, err := json.Marshal(q.Lc.QueueStats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
@@ -0,0 +1,172 @@
+package goomo
+
+import (
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// RecordingRequest starts a recording, both fields are optional
+type RecordingRequest struct {
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Recordings lists, starts, stops, deletes and downloads the recordings of the RecordingLibrary
+type Recordings struct {
+	g *Goomo
+}
+
+func recordingStatus(err error) int {
+	switch err {
+	case ErrRecordingNotFound:
+		return http.StatusNotFound
+	case ErrRecordingActive, ErrNoRecording:
+		return http.StatusConflict
+	case ErrQuotaExceeded:
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+func writeRecordingError(w http.ResponseWriter, err error) {
+	status := recordingStatus(err)
+	if status == http.StatusInternalServerError {
+		logger.Error(err)
+	}
+	writeAPIError(w, status, err.Error())
+}
+
+func (rs *Recordings) library(w http.ResponseWriter) (*RecordingLibrary, bool) {
+	l, err := rs.g.Recordings()
+	if err != nil {
+		logger.Error(err)
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return nil, false
+	}
+	return l, true
+}
+
+// ServeHTTP returns the RecordingList for GET and starts a recording for POST
+func (rs *Recordings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l, ok := rs.library(w)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, l.List())
+	case http.MethodPost:
+		var request RecordingRequest
+		if r.ContentLength != 0 && !decodeBody(w, r, &request) {
+			return
+		}
+		recording, err := rs.g.StartRecording(request.Name, request.Metadata)
+		if err != nil {
+			writeRecordingError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, recording)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path)
+	}
+}
+
+// serveRecording returns the recording for GET and deletes it for DELETE
+func (rs *Recordings) serveRecording(w http.ResponseWriter, r *http.Request) {
+	l, ok := rs.library(w)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	switch r.Method {
+	case http.MethodGet:
+		recording, err := l.Get(id)
+		if err != nil {
+			writeRecordingError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, recording)
+	case http.MethodDelete:
+		err := l.Delete(id)
+		if err != nil {
+			writeRecordingError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path)
+	}
+}
+
+// stop stops the recording if it is the running one
+func (rs *Recordings) stop(w http.ResponseWriter, r *http.Request) {
+	l, ok := rs.library(w)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path)
+		return
+	}
+	recording, err := l.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	if !recording.Active {
+		writeRecordingError(w, ErrNoRecording)
+		return
+	}
+	recording, err = rs.g.StopRecording()
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recording)
+}
+
+func (rs *Recordings) video(w http.ResponseWriter, r *http.Request) {
+	l, ok := rs.library(w)
+	if !ok {
+		return
+	}
+	recording, err := l.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	serveRecordingVideo(w, r, l, recording)
+}
+
+// serveRecordingVideo streams the video from disk with support for range requests
+func serveRecordingVideo(w http.ResponseWriter, r *http.Request, l *RecordingLibrary, recording Recording) {
+	f, err := os.Open(l.Path(recording.ID))
+	if os.IsNotExist(err) {
+		writeAPIError(w, http.StatusNotFound, "recording "+recording.ID+" has no video yet")
+		return
+	}
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": recording.Name + recordingExt})
+	if disposition == "" {
+		// names which cannot be encoded are replaced by the id
+		disposition = mime.FormatMediaType("attachment", map[string]string{"filename": recording.ID + recordingExt})
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -0,0 +1,65 @@
+package goomo
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type Sensors struct {
+	Registry *SensorRegistry
+}
+
+type SensorStatus struct {
+	Stream string `json:"stream"`
+	Port   string `json:"port"`
+	Active bool   `json:"active"`
+}
+
+// ServeHTTP lists all streams for /sensors and starts or stops one for /sensors/{stream}/{option}
+func (s *Sensors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	stream, ok := vars["stream"]
+	if !ok {
+		s.list(w)
+		return
+	}
+	if _, ok := s.Registry.Port(stream); !ok {
+		http.Error(w, "unknown stream "+stream, 404)
+		return
+	}
+
+	var err error
+	switch vars["option"] {
+	case "start":
+		err = s.Registry.Start(stream)
+	case "stop":
+		err = s.Registry.Stop(stream)
+	default:
+		http.Error(w, "option has to be \"start\" or \"stop\"", 400)
+		return
+	}
+	writeCommandError(w, err)
+}
+
+func (s *Sensors) list(w http.ResponseWriter) {
+	response := make([]SensorStatus, 0)
+	for _, stream := range s.Registry.Streams() {
+		port, _ := s.Registry.Port(stream)
+		response = append(response, SensorStatus{
+			Stream: stream,
+			Port:   port,
+			Active: s.Registry.IsActive(stream),
+		})
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
@@ -5,28 +5,30 @@ import (
 	"net/http"
 )
 
+// Settings switches the modules of the ModuleRegistry, the keys are the module ids
 type Settings struct {
 	g *Goomo
 }
 
-const (
-	debugScreenStr   = "debug-screen"
-	postitAIStr      = "postit-ai"
-	trafficsignAIStr = "trafficsign-ai"
-	slamStr          = "slam"
-	videoCaptureStr  = "video-capture"
-)
+// moduleStatus returns the HTTP status for an error of ModuleRegistry.Apply
+func moduleStatus(err error) int {
+	if e, ok := err.(*ModuleError); ok {
+		if e.Unknown {
+			return http.StatusBadRequest
+		}
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
 
+// ServeHTTP returns the state of every module for GET and switches the modules of the body for PUT,
+// the response of PUT contains only the switched modules
 func (s *Settings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	response := map[string]bool{}
+	var response map[string]bool
 
 	switch r.Method {
 	case http.MethodGet:
-		response[debugScreenStr] = s.g.IsDebugScreenActive()
-		response[postitAIStr] = s.g.IsPostitAIActive()
-		response[trafficsignAIStr] = s.g.IsTrafficSignAIActive()
-		response[slamStr] = s.g.IsSlamActive()
-		response[videoCaptureStr] = s.g.IsVideoCaptureRunning()
+		response = s.g.modules.States()
 	case http.MethodPut:
 		if r.Body == nil {
 			http.Error(w, "Please send a request body", 400)
@@ -38,12 +40,12 @@ func (s *Settings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 400)
 			return
 		}
-
-		deactivateAndToggle(&body, &response, debugScreenStr, s.g.ActivateDebugScreen, s.g.DeactivateDebugScreen, slamStr, s.g.DeactivateSlam)
-		toggle(&body, &response, postitAIStr, s.g.ActivatePostitAI, s.g.DeactivatePostitAI)
-		toggle(&body, &response, trafficsignAIStr, s.g.ActivateTrafficSignAI, s.g.DeactivateTrafficSignAI)
-		deactivateAndToggle(&body, &response, slamStr, s.g.ActivateSlam, s.g.DeactivateSlam, debugScreenStr, s.g.DeactivateDebugScreen)
-		toggle(&body, &response, videoCaptureStr, s.StartVideoCapture, s.g.StopVideoCapture)
+		response, err = s.g.modules.Apply(body)
+		if err != nil {
+			logger.Errorf("switching modules: %v", err)
+			http.Error(w, err.Error(), moduleStatus(err))
+			return
+		}
 	}
 
 	responseJSON, err := json.Marshal(response)
@@ -57,34 +59,7 @@ func (s *Settings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseJSON)
 }
 
-func toggle(body *map[string]bool, response *map[string]bool, key string, activate, deactivate func()) {
-	b, ok := (*body)[key]
-	if ok {
-		if b {
-			activate()
-		} else {
-			deactivate()
-		}
-		(*response)[key] = b
-	}
-}
-
-func deactivateAndToggle(body *map[string]bool, response *map[string]bool, key string, activate, deactivate func(), otherKey string, deactivateOther func()) {
-	b, ok := (*body)[key]
-	if ok {
-		if b {
-			deactivateOther()
-			activate()
-		} else {
-			deactivate()
-		}
-		(*response)[key] = b
-		(*response)[otherKey] = false
-	}
-}
-
-const videofilePath = "video/tmp.h264"
-
-func (s *Settings) StartVideoCapture() {
-	s.g.StartVideoCapture(videofilePath)
+// modules lists the modules with their dependencies and conflicts
+func (s *Settings) modules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.g.modules.List())
 }
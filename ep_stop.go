@@ -0,0 +1,14 @@
+package goomo
+
+import (
+	"net/http"
+)
+
+// EmergencyStop sets both velocities to 0 ahead of all queued commands, bypassing the ramps of the Shaper
+type EmergencyStop struct {
+	Shaper *MotionShaper
+}
+
+func (s *EmergencyStop) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeCommandError(w, s.Shaper.Stop())
+}
@@ -4,30 +4,52 @@ import (
 	"errors"
 	"fmt"
 	"gocv.io/x/gocv"
+	"image"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// MJPGStream sends the latest JPG to every client, clients which are not ready miss the frame.
+// Clients can ask for a StreamProfile, the frames are re-encoded once per profile.
 type MJPGStream struct {
 	m        sync.Mutex
 	s        map[chan []byte]struct{}
+	profiles map[StreamProfile]*transcoder
 	Interval time.Duration
 }
 
+// StreamProfile is selected with the query parameters fps, width, quality and gray of /stream, zero values keep the original
+type StreamProfile struct {
+	FPS     int
+	Width   int
+	Quality int
+	Gray    bool
+}
+
+// transcoder converts the latest frame of its MJPGStream for the clients of one profile
+type transcoder struct {
+	profile StreamProfile
+	in      chan []byte
+	out     *MJPGStream
+	clients int
+}
+
 func NewStream() *MJPGStream {
 	return &MJPGStream{
-		s: make(map[chan []byte]struct{}),
+		s:        make(map[chan []byte]struct{}),
+		profiles: make(map[StreamProfile]*transcoder),
 	}
 }
 
 func NewStreamWithInterval(interval time.Duration) *MJPGStream {
-	return &MJPGStream{
-		s:        make(map[chan []byte]struct{}),
-		Interval: interval,
-	}
+	s := NewStream()
+	s.Interval = interval
+	return s
 }
 
 func (s *MJPGStream) Close() error {
@@ -38,6 +60,11 @@ func (s *MJPGStream) Close() error {
 		delete(s.s, c)
 	}
 	s.s = nil
+	for p, t := range s.profiles {
+		close(t.in)
+		t.out.Close()
+		delete(s.profiles, p)
+	}
 	return nil
 }
 
@@ -53,9 +80,136 @@ func (s *MJPGStream) Update(b []byte) error {
 		default:
 		}
 	}
+	for _, t := range s.profiles {
+		// a transcoder which is still busy gets the newest frame instead of the one it did not take yet
+		select {
+		case <-t.in:
+		default:
+		}
+		t.in <- b
+	}
 	return nil
 }
 
+// ParseStreamProfile reads the profile from the query parameters fps (1-30), width (16-1920), quality (1-100) and gray
+func ParseStreamProfile(query url.Values) (StreamProfile, error) {
+	var p StreamProfile
+	for _, param := range []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"fps", &p.FPS, 1, 30},
+		{"width", &p.Width, 16, 1920},
+		{"quality", &p.Quality, 1, 100},
+	} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < param.min || i > param.max {
+			return p, fmt.Errorf("%s has to be a number within [%d, %d]", param.name, param.min, param.max)
+		}
+		*param.value = i
+	}
+	if v := query.Get("gray"); v != "" {
+		gray, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("gray has to be true or false")
+		}
+		p.Gray = gray
+	}
+	return p, nil
+}
+
+// reencodes tells whether the frames have to be decoded, otherwise only the frame rate is reduced
+func (p StreamProfile) reencodes() bool {
+	return p.Width != 0 || p.Quality != 0 || p.Gray
+}
+
+func (p StreamProfile) encode(b []byte) ([]byte, error) {
+	var flags gocv.IMReadFlag = gocv.IMReadColor
+	if p.Gray {
+		flags = gocv.IMReadGrayScale
+	}
+	mat, err := gocv.IMDecode(b, flags)
+	if err != nil {
+		return nil, fmt.Errorf("decoding frame: %v", err)
+	}
+	defer mat.Close()
+	if mat.Empty() {
+		return nil, fmt.Errorf("decoding frame: no image")
+	}
+	if p.Width != 0 && p.Width < mat.Cols() {
+		height := mat.Rows() * p.Width / mat.Cols()
+		gocv.Resize(mat, &mat, image.Point{p.Width, height}, 0, 0, gocv.InterpolationArea)
+	}
+	quality := p.Quality
+	if quality == 0 {
+		quality = 95
+	}
+	return gocv.IMEncodeWithParams(gocv.JPEGFileExt, mat, []int{gocv.IMWriteJpegQuality, quality})
+}
+
+func (t *transcoder) run() {
+	var last time.Time
+	for b := range t.in {
+		if t.profile.FPS != 0 {
+			if time.Since(last) < time.Second/time.Duration(t.profile.FPS) {
+				continue
+			}
+			last = time.Now()
+		}
+		if t.profile.reencodes() {
+			var err error
+			b, err = t.profile.encode(b)
+			if err != nil {
+				logger.Error(err)
+				continue
+			}
+		}
+		t.out.Update(b)
+	}
+}
+
+// acquire returns the stream of the profile, which is started for its first client
+func (s *MJPGStream) acquire(p StreamProfile) (*MJPGStream, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.s == nil {
+		return nil, errors.New("stream was closed")
+	}
+	t, ok := s.profiles[p]
+	if !ok {
+		t = &transcoder{
+			profile: p,
+			in:      make(chan []byte, 1),
+			out:     NewStream(),
+		}
+		s.profiles[p] = t
+		go t.run()
+	}
+	t.clients++
+	return t.out, nil
+}
+
+// release stops the transcoder of the profile once its last client left
+func (s *MJPGStream) release(p StreamProfile) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	t, ok := s.profiles[p]
+	if !ok {
+		return
+	}
+	t.clients--
+	if t.clients == 0 {
+		close(t.in)
+		t.out.Close()
+		delete(s.profiles, p)
+	}
+}
+
 func (s *MJPGStream) StartJpgStream(jpgChan chan JPG) {
 	for jpg := range jpgChan {
 		err := s.Update(jpg)
@@ -95,8 +249,11 @@ func (s *MJPGStream) destroy(c chan []byte) {
 	s.m.Unlock()
 }
 
+// NWatch returns the number of direct clients and profile transcoders which receive the frames
 func (s *MJPGStream) NWatch() int {
-	return len(s.s)
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.s) + len(s.profiles)
 }
 
 func (s *MJPGStream) Current() []byte {
@@ -107,7 +264,27 @@ func (s *MJPGStream) Current() []byte {
 	return <-c
 }
 
+// ServeHTTP streams the original frames, or those of the StreamProfile in the query
 func (s *MJPGStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	profile, err := ParseStreamProfile(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if profile == (StreamProfile{}) {
+		s.serve(w)
+		return
+	}
+	stream, err := s.acquire(profile)
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer s.release(profile)
+	stream.serve(w)
+}
+
+func (s *MJPGStream) serve(w http.ResponseWriter) {
 	c := make(chan []byte)
 	s.add(c)
 	defer s.destroy(c)
@@ -6,7 +6,8 @@ import (
 )
 
 type StreamOpts struct {
-	Lc *LoomoCommunicator
+	Lc   *LoomoCommunicator
+	Port string
 }
 
 func (so *StreamOpts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -14,11 +15,12 @@ func (so *StreamOpts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
 	switch mux.Vars(r)["option"] {
 	case "start":
-		err = so.Lc.ExecuteCommand(&CSSTCommand{"1339", "CAM"})
+		err = so.Lc.ExecuteCommand(&CSSTCommand{so.Port, "CAM"})
 	case "stop":
-		err = so.Lc.ExecuteCommand(&CESTCommand{"1339", "CAM"})
-	}
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+		err = so.Lc.ExecuteCommand(&CESTCommand{so.Port, "CAM"})
+	default:
+		http.Error(w, "option has to be \"start\" or \"stop\"", 400)
+		return
 	}
+	writeCommandError(w, err)
 }
@@ -0,0 +1,177 @@
+package goomo
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultTeleopTimeout = 500 * time.Millisecond
+
+// TwistMessage is sent by teleop clients at a steady rate, e.g. every 100ms
+type TwistMessage struct {
+	Lv float32 `json:"lv"`
+	Av float32 `json:"av"`
+}
+
+// TeleopReport is sent back after every twist and when the deadman stopped the Loomo. Lv and Av were last sent
+// by the MotionShaper, which ramps them towards the clamped targets.
+type TeleopReport struct {
+	Lv       float32 `json:"lv"`
+	Av       float32 `json:"av"`
+	TargetLv float32 `json:"targetLv"`
+	TargetAv float32 `json:"targetAv"`
+	Stopped  bool    `json:"stopped"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Teleop drives the Loomo via WebSocket through the Shaper. If no twist arrives within Timeout or the socket closes,
+// the Loomo is stopped. The client needs the control lease, with tokens configured the token has to be sent
+// as query parameter access_token.
+type Teleop struct {
+	Shaper   *MotionShaper
+	Access   *Access
+	Timeout  time.Duration
+	upgrader websocket.Upgrader
+}
+
+func NewTeleop(shaper *MotionShaper, access *Access, timeout time.Duration) *Teleop {
+	return &Teleop{
+		Shaper:  shaper,
+		Access:  access,
+		Timeout: timeout,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// authorize returns the client which may drive
+func (t *Teleop) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	client, ok := t.Access.client(r)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+		return "", false
+	}
+	if err := t.Access.checkLease(client); err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return "", false
+	}
+	return client, true
+}
+
+func (t *Teleop) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, ok := t.authorize(w, r)
+	if !ok {
+		return
+	}
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	defer conn.Close()
+	logger.Infof("Teleop by %s started", r.RemoteAddr)
+
+	twists := make(chan TwistMessage)
+	go func() {
+		defer close(twists)
+		for {
+			var twist TwistMessage
+			err := conn.ReadJSON(&twist)
+			if err != nil {
+				logger.Debugf("reading twist: %v", err)
+				return
+			}
+			twists <- twist
+		}
+	}()
+
+	t.drive(conn, client, twists)
+	// the reader stops when the connection is closed
+	conn.Close()
+	for range twists {
+	}
+	logger.Infof("Teleop by %s stopped", r.RemoteAddr)
+}
+
+func (t *Teleop) drive(conn *websocket.Conn, client string, twists chan TwistMessage) {
+	deadman := time.NewTimer(t.Timeout)
+	defer deadman.Stop()
+	var sent TeleopReport
+
+	stop := func() {
+		err := t.Shaper.Stop()
+		sent = TeleopReport{Stopped: true}
+		if err != nil {
+			logger.Errorf("stopping teleop: %v", err)
+			sent.Error = err.Error()
+		}
+	}
+	defer func() {
+		if !sent.Stopped {
+			stop()
+		}
+	}()
+	// the Loomo is standing still until the first twist
+	sent.Stopped = true
+
+	for {
+		select {
+		case twist, ok := <-twists:
+			if !ok {
+				return
+			}
+			// the value of an expired timer may already have been received below
+			if !deadman.Stop() {
+				select {
+				case <-deadman.C:
+				default:
+				}
+			}
+			deadman.Reset(t.Timeout)
+			report := t.twist(twist, client, sent)
+			if conn.WriteJSON(report) != nil {
+				return
+			}
+			report.Error = ""
+			sent = report
+		case <-deadman.C:
+			if !sent.Stopped {
+				logger.Infof("Teleop received no twist for %v, stopping", t.Timeout)
+				stop()
+				if conn.WriteJSON(sent) != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// twist sets the velocities as targets of the Shaper and reports them clamped with the sent velocities,
+// or the previous targets with an error
+func (t *Teleop) twist(twist TwistMessage, client string, sent TeleopReport) TeleopReport {
+	failed := func(err error) TeleopReport {
+		sent.Lv, sent.Av = t.Shaper.Current()
+		sent.Error = err.Error()
+		return sent
+	}
+	for name, v := range map[string]float32{"lv": twist.Lv, "av": twist.Av} {
+		if err := validFloat(name, v); err != nil {
+			return failed(err)
+		}
+	}
+	if err := t.Access.checkLease(client); err != nil {
+		return failed(err)
+	}
+	lv, av, err := t.Shaper.Set(twist.Lv, twist.Av)
+	if err != nil {
+		return failed(err)
+	}
+	report := TeleopReport{TargetLv: lv, TargetAv: av}
+	report.Lv, report.Av = t.Shaper.Current()
+	return report
+}
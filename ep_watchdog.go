@@ -0,0 +1,25 @@
+package goomo
+
+import (
+	"net/http"
+)
+
+// ServeHTTP returns the WatchdogStatus
+func (w *Watchdog) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, w.Status())
+}
+
+// reset clears the latched fault for POST, so the AIs drive again
+func (w *Watchdog) reset(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		writeAPIError(rw, http.StatusMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path)
+		return
+	}
+	err := w.Reset()
+	if err != nil {
+		writeAPIError(rw, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, w.Status())
+}
@@ -18,12 +18,16 @@ type PositionedObjects []PositionedObject
 type FeatureWebsocket struct {
 	Features     chan [][]Feature
 	TrafficSigns chan TrafficSign
-	upgrader     websocket.Upgrader
+	// Distances locate the post-its, it defaults to the SharedDistanceLookup
+	Distances *DistanceLookup
+	upgrader  websocket.Upgrader
 }
 
 func NewPositionWebsocket() FeatureWebsocket {
 	pws := FeatureWebsocket{
-		Features: make(chan [][]Feature),
+		Features:     make(chan [][]Feature),
+		TrafficSigns: make(chan TrafficSign),
+		Distances:    SharedDistanceLookup(),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -60,7 +64,7 @@ func (pws FeatureWebsocket) communicate(conn *websocket.Conn) {
 			if !ok {
 				return
 			}
-			pos := PostitsToPositionedObjects(pgs)
+			pos := PostitsToPositionedObjects(pws.Distances, pgs)
 			if trafficSignSet {
 				log.Printf("a traffic sign was set")
 				pos = append(pos, TrafficSignToPositionedObject(currentTS))
@@ -80,7 +84,7 @@ func (pws FeatureWebsocket) communicate(conn *websocket.Conn) {
 	}
 }
 
-func PostitsToPositionedObjects(postits [][]Feature) PositionedObjects {
+func PostitsToPositionedObjects(distances *DistanceLookup, postits [][]Feature) PositionedObjects {
 	pos := make(PositionedObjects, 0, 15)
 	for i, p := range postits {
 		var objectType string
@@ -90,7 +94,7 @@ func PostitsToPositionedObjects(postits [][]Feature) PositionedObjects {
 			objectType = "green"
 		}
 		for _, postit := range p {
-			dx, dy := SharedDistanceLookup().Distance(postit.imagePos.X, postit.imagePos.Y)
+			dx, dy := distances.Distance(postit.imagePos.X, postit.imagePos.Y)
 			po := PositionedObject{
 				X:          float32(dx),
 				Y:          float32(dy),
@@ -1,275 +1,522 @@
 package goomo
 
 import (
+	"context"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"gocv.io/x/gocv"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
 )
 
-var DebugScreen = false
 var logger = func() *zap.SugaredLogger { l, _ := zap.NewDevelopment(); return l.Sugar() }()
-var window *gocv.Window
+
+const (
+	defaultCameraPort      = "1339"
+	defaultShutdownTimeout = 5 * time.Second
+)
 
 type Goomo struct {
-	wg     *sync.WaitGroup
-	lc     *LoomoCommunicator
-	dp     *DataProcessor
-	matMux *MatMultiplexer
-	jpgMux *JPGMultiplexer
-	pt     *PostitTracker
-	tT     *TrafficSignTracker
-	ai     *MovementAI
-	slam   *MonoSLAM
-	vm     *VideoMaker
+	// wg counts the goroutines which have to finish on shutdown, e.g. the LoomoCommunicator, SLAM and the video writers
+	wg *sync.WaitGroup
+	// ctx ends the runtime after the modules were deactivated, stopped is closed once the shutdown finished
+	ctx        context.Context
+	cancel     context.CancelFunc
+	started    bool
+	stopped    chan bool
+	lc         *LoomoCommunicator
+	cameraPort string
+	sensors    *SensorRegistry
+	imu        *IMUHandler
+	odometry   *OdometryHandler
+	ultrasonic *UltrasonicHandler
+	depth      *DepthHandler
+	modules    *ModuleRegistry
+	watchdog   *Watchdog
+	shaper     *MotionShaper
+	screen     *DebugScreen
+	access     *Access
+	telemetry  *TelemetryHub
+	metrics    *moduleMetrics
+	config     *Config
+	router     *mux.Router
+	routerOnce sync.Once
+	dp         *DataProcessor
+	matMux     *MatMultiplexer
+	jpgMux     *JPGMultiplexer
+	pt         *PostitTracker
+	tT         *TrafficSignTracker
+	ai         *MovementAI
+	slam       *MonoSLAM
+	vm         *VideoMaker
+	// vmDone is closed when the VideoMaker closed its writer
+	vmDone chan bool
+	// videoLock serializes starting and stopping the video capture and its recording
+	videoLock sync.Mutex
+	// recordings is opened on first use, see Recordings
+	recordings     *RecordingLibrary
+	recordingsLock sync.Mutex
+	sessions       []*SessionRecorder
+	sessionsLock   sync.Mutex
 }
 
+// NewGoomo loads the config from GOOMO_CONFIG or goomo.json, if there is none the defaults are used
 func NewGoomo() *Goomo {
+	config := DefaultConfig()
+	if path := configPath(); path != "" {
+		var err error
+		config, err = LoadConfig(path)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Infof("Loaded config %s", path)
+	}
+	return NewGoomoWithConfig(config)
+}
+
+// NewGoomoWithConfig creates a Goomo whose modules are set up with config, which has to be valid
+func NewGoomoWithConfig(config *Config) *Goomo {
+	g := NewGoomoFor(config.newLoomoCommunicator(), config.CameraPort)
+	g.applyConfig(config)
+	return g
+}
+
+// NewGoomoFor creates a Goomo which talks to the Loomo via lc and receives its camera stream on cameraPort.
+// The other sensor streams are received on the four ports following cameraPort,
+// so several Goomos in one process need camera ports which are at least 5 apart.
+func NewGoomoFor(lc *LoomoCommunicator, cameraPort string) *Goomo {
 	g := Goomo{}
 	g.wg = &sync.WaitGroup{}
-	g.lc = NewLoomoCommunicator()
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.stopped = make(chan bool)
+	g.config = DefaultConfig()
+	g.lc = lc
+	g.metrics = newModuleMetrics(NewMetricsRegistry())
+	lc.metrics = g.metrics
+	g.cameraPort = cameraPort
+	g.screen = NewDebugScreen("Debug Screen")
+	g.access = NewAccess()
+	g.telemetry = NewTelemetryHub()
+	g.shaper = NewMotionShaper(lc, g.config.MaxLv, g.config.MaxAv, g.config.Motion)
+	g.access.OnExpire = func(holder string) {
+		err := g.shaper.Stop()
+		if err != nil {
+			logger.Errorf("stopping after the lease of %s expired: %v", holder, err)
+		}
+	}
+	g.access.OnRelease = func(holder string) {
+		err := g.shaper.Stop()
+		if err != nil {
+			logger.Errorf("stopping after %s released the lease: %v", holder, err)
+		}
+	}
+	g.watchdog = NewWatchdog()
+	g.watchdog.telemetry = g.telemetry
+	g.watchdog.metrics = g.metrics
+	g.watchdog.OnFault = func(fault WatchdogFault) {
+		err := g.shaper.Hold()
+		if err != nil {
+			logger.Errorf("stopping after the watchdog fault of %s: %v", fault.Source, err)
+		}
+	}
+	g.watchdog.OnReset = g.shaper.Release
 	g.dp = &DataProcessor{
 		OutboundJPG: make(chan JPG),
 		OutboundMat: make(chan *ManagedMat),
-	}
-	g.lc.RegisterHandler("SCAM", g.dp)
+		Screen:      g.screen,
+		Watchdog:    g.watchdog,
+		metrics:     g.metrics,
+	}
+	g.sensors = NewSensorRegistry(lc)
+	g.imu = &IMUHandler{Outbound: make(chan *IMUData)}
+	g.odometry = &OdometryHandler{Outbound: make(chan *OdometryData)}
+	g.ultrasonic = &UltrasonicHandler{Outbound: make(chan *UltrasonicData)}
+	g.depth = &DepthHandler{Outbound: make(chan *DepthData)}
+	g.registerSensors()
 	g.jpgMux = &JPGMultiplexer{
 		Inbound:       g.dp.OutboundJPG,
 		outboundMutex: &sync.Mutex{},
 		outbounds:     make(map[string]chan JPG),
+		metrics:       g.metrics,
 	}
 	g.matMux = &MatMultiplexer{
 		Inbound:       g.dp.OutboundMat,
 		outboundMutex: &sync.Mutex{},
 		outbounds:     make(map[string]chan *ManagedMat),
+		metrics:       g.metrics,
 	}
+	g.modules = NewModuleRegistry()
+	g.registerModules()
+	g.watch()
 	return &g
 }
 
-func (g *Goomo) Start() {
-	cmdsReady := make(chan bool)
+// Start runs the Goomo until ctx is done, afterwards it is shut down within the ShutdownTimeout of the config, see Wait
+func (g *Goomo) Start(ctx context.Context) {
+	g.started = true
+	err := g.lc.Start(g.ctx)
+	if err != nil {
+		logger.Fatal("starting Command loop: ", err)
+	}
 	g.wg.Add(1)
 	go func() {
-		err := g.lc.Connect()
-		if err != nil {
-			logger.Fatal("connecting to Loomo: ", err)
-		}
-		err = g.lc.Start()
-		if err != nil {
-			logger.Fatal("starting Command loop: ", err)
-		}
-		cmdsReady <- true
 		g.lc.Wait()
 		g.wg.Done()
-		g.lc.Close()
 	}()
-	<-cmdsReady
-	err := g.lc.ExecuteCommand(&CSSTCommand{"1339", "CAM"})
-	if err != nil {
+	// the camera stream is requested again whenever the connection is re-established
+	err = g.sensors.Start(CameraStream)
+	if commandCause(err) == ErrNotConnected {
+		logger.Info("camera stream is requested as soon as the Loomo is connected")
+	} else if err != nil {
 		logger.Errorf("starting camera stream: %v", err)
 	}
-	go g.jpgMux.Multiplex()
-	go g.matMux.Multiplex()
-
-	//g.wg.Wait()
-}
-
-func (g *Goomo) Wait() {
-	g.wg.Wait()
+	go g.publishTelemetry(g.ctx)
+	go g.watchdog.Start(g.ctx)
+	go g.shaper.Start(g.ctx)
+	go g.jpgMux.Multiplex(g.ctx)
+	go g.matMux.Multiplex(g.ctx)
+	go func() {
+		<-ctx.Done()
+		g.shutdown()
+	}()
 }
 
-func (g *Goomo) ActivateHTTPEndpoints() {
-	lc := g.lc
-
-	jpgChan := make(chan JPG)
-	g.jpgMux.Add("http", jpgChan)
-	stream := NewStream()
-	go stream.StartJpgStream(jpgChan)
+// shutdown deactivates the modules first, so the AIs stop sending, then the LoomoCommunicator writes zero velocities
+// and a CEST for every open stream. The session recorders are closed after these last commands.
+func (g *Goomo) shutdown() {
+	defer close(g.stopped)
+	timeout := g.config.ShutdownTimeout.Duration
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	logger.Infof("Shutting down within %v", timeout)
 
-	motion := &Motion{Lc: lc}
-	streamOpts := &StreamOpts{Lc: lc}
-	settings := &Settings{g: g}
-	downloadVideo := &DownloadVideo{}
-
-	r := mux.NewRouter()
-	r.Handle("/stream", stream)
-	r.Handle("/stream/{option}", streamOpts)
-	r.Handle("/motion", motion)
-	r.Handle("/settings", settings)
-	r.Handle("/video", downloadVideo)
+	err := g.modules.DeactivateAll()
+	if err != nil {
+		logger.Errorf("deactivating modules: %v", err)
+	}
+	g.cancel()
+	if g.started && !waitContext(ctx, g.lc.Wait) {
+		logger.Warnf("the Loomo could not be stopped within %v", timeout)
+	}
+	g.closeSessions()
+	if !waitContext(ctx, g.wg.Wait) {
+		logger.Warnf("the modules did not stop within %v", timeout)
+		return
+	}
+	logger.Info("Shut down")
+}
 
-	originsOk := handlers.AllowedOrigins([]string{"http://localhost:4200"})
-	headersOk := handlers.AllowedHeaders([]string{"content-type"})
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "PUT", "OPTIONS"})
+// waitContext returns true if wait returned before ctx was done
+func waitContext(ctx context.Context, wait func()) bool {
+	done := make(chan bool)
 	go func() {
-		err := http.ListenAndServe(":4000", handlers.CORS(originsOk, headersOk, methodsOk)(r))
-		if err != nil {
-			log.Fatalf("listening mjpeg on port 4000: %v", err)
-		}
+		wait()
+		close(done)
 	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
-func (g *Goomo) IsDebugScreenActive() bool {
-	return DebugScreen
+// InterruptContext is done on SIGINT or SIGTERM, e.g. for Start, a second signal exits immediately
+func InterruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Infof("Received %v", sig)
+		cancel()
+		<-signals
+		logger.Warn("Exiting without shutdown")
+		os.Exit(1)
+	}()
+	return ctx
 }
 
-func (g *Goomo) ActivateDebugScreen() {
-	if g.IsDebugScreenActive() {
+func (g *Goomo) registerSensors() {
+	base, err := strconv.Atoi(g.cameraPort)
+	if err != nil {
+		logger.Errorf("camera port %q is not a number: %v", g.cameraPort, err)
 		return
 	}
-
-	if window == nil {
-		window = gocv.NewWindow("Debug Screen")
+	sensorHandlers := []SensorHandler{g.dp, g.imu, g.odometry, g.ultrasonic, g.depth}
+	for i, handler := range sensorHandlers {
+		err = g.sensors.Register(handler, strconv.Itoa(base+i))
+		if err != nil {
+			logger.Errorf("registering stream %s: %v", handler.Stream(), err)
+		}
 	}
+}
 
-	DebugScreen = true
+// Sensors returns the registry through which the sensor streams are started and stopped
+func (g *Goomo) Sensors() *SensorRegistry {
+	return g.sensors
 }
 
-func (g *Goomo) DeactivateDebugScreen() {
-	DebugScreen = false
+// IMU receives the frames of the IMUStream while it is started, the other sensors work alike
+func (g *Goomo) IMU() chan *IMUData {
+	return g.imu.Outbound
+}
 
-	if window != nil {
-		window.Close()
-		window = nil
-	}
+func (g *Goomo) Odometry() chan *OdometryData {
+	return g.odometry.Outbound
 }
 
-const postitTrackerMuxId = "pt"
+func (g *Goomo) Ultrasonic() chan *UltrasonicData {
+	return g.ultrasonic.Outbound
+}
 
-func (g *Goomo) IsPostitAIActive() bool {
-	if g.ai == nil || g.pt == nil {
-		return false
-	}
-	if g.ai.InboundPostits == nil || g.pt.Inbound == nil || g.pt.Outbound == nil {
-		return false
-	}
-	if !g.matMux.Has(postitTrackerMuxId) {
-		return false
-	}
-	return true
+func (g *Goomo) Depth() chan *DepthData {
+	return g.depth.Outbound
 }
 
-func (g *Goomo) ActivatePostitAI() {
-	if g.IsPostitAIActive() {
-		return
+func (g *Goomo) applyConfig(config *Config) {
+	g.config = config
+	g.access.LeaseDuration = config.LeaseDuration.Duration
+	g.shaper.configure(config.MaxLv, config.MaxAv, config.Motion)
+	for token, client := range config.Tokens {
+		g.access.AddToken(token, client)
 	}
+	g.watch()
+}
 
-	mats := make(chan *ManagedMat)
-	features := make(chan [][]Feature)
-
-	// init postit tracker
-	if g.pt == nil {
-		g.pt = &PostitTracker{}
-	}
-	g.pt.Inbound = mats
-	g.pt.Outbound = features
+// distanceLookup returns the lookup for the eye height of this robot
+func (g *Goomo) distanceLookup() *DistanceLookup {
+	return SharedDistanceLookupFor(g.config.EyeHeight)
+}
 
-	// init postit ai
-	if g.ai == nil {
-		g.ai = NewMovementAI(g.lc.Cmds)
-	}
+// Config returns the configuration this Goomo was created with
+func (g *Goomo) Config() *Config {
+	return g.config
+}
 
-	// add to matmux
-	g.matMux.Add(postitTrackerMuxId, mats)
+func (g *Goomo) newMovementAI() *MovementAI {
+	ai := NewMovementAI(g.shaper)
+	ai.telemetry = g.telemetry
+	ai.watchdog = g.watchdog
+	ai.metrics = g.metrics
+	ai.maxLv = g.config.MaxLv
+	ai.maxAv = g.config.MaxAv
+	ai.distances = g.distanceLookup()
+	return ai
+}
 
-	// start go routines
-	go g.pt.StartPostitTracker()
-	go g.ai.StartPostitAI(g.pt.Outbound)
+// Metrics returns the registry which is served at /metrics, e.g. to add metrics of own modules
+func (g *Goomo) Metrics() *MetricsRegistry {
+	return g.metrics.registry
 }
 
-func (g *Goomo) DeactivatePostitAI() {
-	// remove from matmux
-	g.matMux.Remove(postitTrackerMuxId)
+// Telemetry returns the hub which is served at /ws/telemetry
+func (g *Goomo) Telemetry() *TelemetryHub {
+	return g.telemetry
+}
 
-	// deactivate postit tracker
-	if g.pt != nil {
-		if g.pt.Inbound != nil {
-			close(g.pt.Inbound)
+// publishTelemetry forwards the connection state and the velocities which were sent to the Loomo until ctx is done
+func (g *Goomo) publishTelemetry(ctx context.Context) {
+	const listenerId = "telemetry"
+	events := make(chan ConnectionEvent, 8)
+	cmds := make(chan Command, 32)
+	g.lc.AddStateListener(listenerId, events)
+	g.lc.AddCommandListener(listenerId, cmds)
+	defer g.lc.RemoveStateListener(listenerId)
+	defer g.lc.RemoveCommandListener(listenerId)
+	g.telemetry.Publish(TopicConnection, g.lc.ConnectionStatus())
+
+	var velocities VelocityTelemetry
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			g.telemetry.Publish(TopicConnection, event)
+		case cmd := <-cmds:
+			switch c := cmd.(type) {
+			case *CLVLCommand:
+				velocities.Lv = c.Lv
+			case *CAVLCommand:
+				velocities.Av = c.Av
+			default:
+				continue
+			}
+			g.telemetry.Publish(TopicVelocities, velocities)
 		}
-		g.pt.Inbound = nil
-		g.pt.Outbound = nil
 	}
+}
+
+// Commands returns the channel through which the modules, e.g. the MovementAI, send their commands to the Loomo
+func (g *Goomo) Commands() chan Command {
+	return g.lc.Cmds
+}
+
+// RecordSession records the frames of all streams and the commands written to the Loomo to path
+// until the recorder is closed, at the latest on shutdown
+func (g *Goomo) RecordSession(path string) (*SessionRecorder, error) {
+	r, err := RecordSession(g.lc, path)
+	if err != nil {
+		return nil, err
+	}
+	g.sessionsLock.Lock()
+	g.sessions = append(g.sessions, r)
+	g.sessionsLock.Unlock()
+	return r, nil
+}
 
-	// deactivate postit ai
-	if g.ai != nil {
-		if g.ai.InboundPostits != nil {
-			close(g.ai.InboundPostits)
+func (g *Goomo) closeSessions() {
+	g.sessionsLock.Lock()
+	defer g.sessionsLock.Unlock()
+	for _, r := range g.sessions {
+		err := r.Close()
+		if err != nil {
+			logger.Errorf("closing session: %v", err)
 		}
-		g.ai.InboundPostits = nil
 	}
+	g.sessions = nil
 }
 
-const trafficSignTrackerMuxId = "ts"
+// ReplaySession feeds a recorded session into the DataProcessor and the sensor handlers until it ends or ctx is done,
+// it is called instead of Start and shuts the Goomo down afterwards.
+// The LoomoCommunicator is not started, so the commands of the modules have to be received from Commands.
+func (g *Goomo) ReplaySession(ctx context.Context, r *SessionReplayer) error {
+	r.RegisterHandler(g.dp)
+	r.RegisterHandler(g.imu)
+	r.RegisterHandler(g.odometry)
+	r.RegisterHandler(g.ultrasonic)
+	r.RegisterHandler(g.depth)
+	go g.jpgMux.Multiplex(g.ctx)
+	go g.matMux.Multiplex(g.ctx)
+	g.shaper.replay()
+	go g.shaper.Start(g.ctx)
+	err := r.Replay(ctx, g.lc.Cmds)
+	g.shutdown()
+	return err
+}
 
-func (g *Goomo) IsTrafficSignAIActive() bool {
-	if g.tT == nil || g.ai == nil {
-		return false
-	}
-	if g.tT.Inbound == nil || g.tT.Outbound == nil || g.ai.InboundTrafficSigns == nil {
-		return false
-	}
-	if !g.matMux.Has(trafficSignTrackerMuxId) {
-		return false
-	}
-	return true
+// Access returns the tokens and the control lease which guard the HTTP endpoints
+func (g *Goomo) Access() *Access {
+	return g.access
 }
 
-func (g *Goomo) ActivateTrafficSignAI() {
-	if g.IsTrafficSignAIActive() {
-		return
-	}
+// Wait blocks until the Goomo was shut down
+func (g *Goomo) Wait() {
+	<-g.stopped
+}
 
-	mats := make(chan *ManagedMat)
-	trafficsigns := make(chan *TrafficSignFeature)
+// Handler returns the router with all endpoints of this Goomo, the camera stream is served once it is called
+func (g *Goomo) Handler() http.Handler {
+	g.routerOnce.Do(func() {
+		g.router = g.newRouter()
+	})
+	return g.router
+}
 
-	// init traffic sign tracker
-	if g.tT == nil {
-		g.tT = &TrafficSignTracker{}
-	}
-	g.tT.Inbound = mats
-	g.tT.Outbound = trafficsigns
+func (g *Goomo) newRouter() *mux.Router {
+	lc := g.lc
 
-	// init traffic sign ai
-	if g.ai == nil {
-		g.ai = NewMovementAI(g.lc.Cmds)
-	}
+	jpgChan := make(chan JPG)
+	g.jpgMux.Add("http", jpgChan)
+	stream := NewStream()
+	go stream.StartJpgStream(jpgChan)
 
-	// add to matmux
-	g.matMux.Add(trafficSignTrackerMuxId, g.tT.Inbound)
+	motion := &Motion{Shaper: g.shaper}
+	head := &Head{Lc: lc}
+	connection := &Connection{Lc: lc}
+	stop := &EmergencyStop{Shaper: g.shaper}
+	queue := &CommandQueue{Lc: lc}
+	command := &HTTPLoomoCommunicator{lc}
+	streamOpts := &StreamOpts{Lc: lc, Port: g.cameraPort}
+	settings := &Settings{g: g}
+	sensors := &Sensors{Registry: g.sensors}
+	downloadVideo := &DownloadVideo{g: g}
+	recordings := &Recordings{g: g}
+	teleop := NewTeleop(g.shaper, g.access, g.config.TeleopTimeout.Duration)
 
-	// start go routines
-	go g.tT.StartTrafficSignTracker()
-	go g.ai.StartTrafficSignAI(g.tT.Outbound)
-}
+	// driving needs the control lease, the other mutating requests only a token
+	drive := func(h http.Handler) http.Handler { return g.access.guard(h, true) }
+	mutate := func(h http.Handler) http.Handler { return g.access.guard(h, false) }
 
-func (g *Goomo) DeactivateTrafficSignAI() {
-	// remove from matmux
-	g.matMux.Remove(trafficSignTrackerMuxId)
+	r := mux.NewRouter()
+	r.Handle("/stream", stream)
+	r.Handle("/stream/debug", g.screen)
+	r.Handle("/stream/{option}", mutate(streamOpts))
+	r.HandleFunc("/snapshot.jpg", g.screen.Snapshot)
+	r.Handle("/motion", drive(motion))
+	r.Handle("/head", drive(head))
+	r.Handle("/connection", connection)
+	r.Handle("/ws/telemetry", g.telemetry)
+	r.Handle("/ws/teleop", teleop)
+	r.Handle("/lease", g.access)
+	r.Handle("/stop", mutate(stop))
+	r.Handle("/queue", queue)
+	r.Handle("/command", drive(command))
+	r.Handle("/sensors", sensors)
+	r.Handle("/sensors/{stream}/{option}", mutate(sensors))
+	r.Handle("/settings", drive(settings))
+	r.HandleFunc("/modules", settings.modules)
+	r.Handle("/video", downloadVideo)
+	r.Handle("/metrics", g.metrics.registry)
+	r.Handle("/watchdog", g.watchdog)
+	r.Handle("/watchdog/reset", drive(http.HandlerFunc(g.watchdog.reset)))
+	r.Handle("/recordings", mutate(recordings))
+	r.Handle("/recordings/{id}", mutate(http.HandlerFunc(recordings.serveRecording)))
+	r.Handle("/recordings/{id}/stop", mutate(http.HandlerFunc(recordings.stop)))
+	r.HandleFunc("/recordings/{id}/video", recordings.video)
+	g.newAPIRouter(r, stream, teleop)
+	return r
+}
 
-	// deactivate traffic sign tacker
-	if g.tT != nil {
-		if g.tT.Inbound != nil {
-			close(g.tT.Inbound)
-		}
-		g.tT.Inbound = nil
-		g.tT.Outbound = nil
+// ActivateHTTPEndpoints serves Handler until the Goomo is shut down
+func (g *Goomo) ActivateHTTPEndpoints() {
+	if !g.access.Enabled() {
+		logger.Warn("no tokens are configured, every client on the network may send commands and drive once it holds the control lease")
 	}
+	listenAndServe(g.ctx, g.config.ShutdownTimeout.Duration, g.config.Listen, g.config.CORSOrigins, g.Handler())
+}
 
-	// deactivate traffic sign ai
-	if g.ai != nil {
-		if g.ai.InboundTrafficSigns != nil {
-			close(g.ai.InboundTrafficSigns)
+// listenAndServe serves handler until ctx is done, then the open requests get timeout to finish
+func listenAndServe(ctx context.Context, timeout time.Duration, addr string, origins []string, handler http.Handler) {
+	originsOk := handlers.AllowedOrigins(origins)
+	headersOk := handlers.AllowedHeaders([]string{"content-type", "authorization"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "PUT", "POST", "DELETE", "OPTIONS"})
+	server := &http.Server{Addr: addr, Handler: handlers.CORS(originsOk, headersOk, methodsOk)(handler)}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("listening mjpeg on %s: %v", addr, err)
 		}
-		g.ai.InboundTrafficSigns = nil
-	}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		// streams and websockets do not end by themselves
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			server.Close()
+		}
+	}()
 }
 
 const videoWriterMuxId = "vw"
 
-func (g *Goomo) IsVideoCaptureRunning() bool {
+func (g *Goomo) isVideoCaptureRunning() bool {
+	g.videoLock.Lock()
+	defer g.videoLock.Unlock()
+	return g.videoCaptureRunning()
+}
+
+// videoCaptureRunning has to be called with videoLock held
+func (g *Goomo) videoCaptureRunning() bool {
 	if g.vm == nil {
 		return false
 	}
@@ -282,8 +529,9 @@ func (g *Goomo) IsVideoCaptureRunning() bool {
 	return true
 }
 
-func (g *Goomo) StartVideoCapture(filename string) {
-	if g.IsVideoCaptureRunning() {
+// startVideoCapture writes the camera images to filename, see StartRecording. It has to be called with videoLock held.
+func (g *Goomo) startVideoCapture(filename string) {
+	if g.videoCaptureRunning() {
 		return
 	}
 
@@ -296,80 +544,97 @@ func (g *Goomo) StartVideoCapture(filename string) {
 
 	g.matMux.Add(videoWriterMuxId, chanMat)
 
-	go g.vm.SaveVideo()
+	// the video is complete once SaveVideo closed the writer
+	vm := *g.vm
+	done := make(chan bool)
+	g.vmDone = done
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(done)
+		vm.SaveVideo()
+	}()
 }
 
-func (g *Goomo) StopVideoCapture() {
+// stopVideoCapture returns after the VideoMaker closed the writer, so the file has its final size.
+// It has to be called with videoLock held.
+func (g *Goomo) stopVideoCapture() {
+	// the mux must not send to Inbound after it was closed
+	g.matMux.Remove(videoWriterMuxId)
 	if g.vm != nil {
 		if g.vm.Inbound != nil {
 			close(g.vm.Inbound)
 		}
 		g.vm.Inbound = nil
 	}
-
-	g.matMux.Remove(videoWriterMuxId)
+	if g.vmDone != nil {
+		<-g.vmDone
+		g.vmDone = nil
+	}
 }
 
-const slamMuxId = "slam"
-
-func (g *Goomo) IsSlamActive() bool {
-	if g.slam == nil {
-		return false
-	}
-	if g.slam.Inbound == nil {
-		return false
-	}
-	if !g.matMux.Has(slamMuxId) {
-		return false
+// Recordings returns the library of the captured videos, which is opened with the recordings config on first use
+func (g *Goomo) Recordings() (*RecordingLibrary, error) {
+	g.recordingsLock.Lock()
+	defer g.recordingsLock.Unlock()
+	if g.recordings == nil {
+		l, err := NewRecordingLibrary(g.config.Recordings.Dir, g.config.Recordings.QuotaMB<<20)
+		if err != nil {
+			return nil, err
+		}
+		l.OnQuotaExceeded = func(r Recording) {
+			_, err := g.StopRecording()
+			if err != nil {
+				logger.Errorf("stopping recording %s: %v", r.ID, err)
+			}
+		}
+		g.recordings = l
 	}
-	return true
+	return g.recordings, nil
 }
 
-func (g *Goomo) ActivateSlam() {
-	if g.IsSlamActive() {
-		return
+// StartRecording captures the camera images into a new recording of the library
+func (g *Goomo) StartRecording(name string, metadata map[string]string) (Recording, error) {
+	l, err := g.Recordings()
+	if err != nil {
+		return Recording{}, err
 	}
-
-	chanMat := make(chan *ManagedMat)
-
-	// init slam
-	if g.slam == nil {
-		g.slam = NewMonoSLAM(
-			"slam_lib/ORBvoc.bin",
-			"slam_lib/settings.yaml",
-			true,
-			false)
+	g.videoLock.Lock()
+	defer g.videoLock.Unlock()
+	if g.videoCaptureRunning() {
+		return Recording{}, ErrRecordingActive
 	}
-
-	// add to matmux
-	g.matMux.Add(slamMuxId, chanMat)
-
-	// start go routines
-	go g.slam.StartSlam(chanMat)
+	r, err := l.Start(name, metadata)
+	if err != nil {
+		return Recording{}, err
+	}
+	g.startVideoCapture(l.Path(r.ID))
+	logger.Infof("Recording %s (%s) started", r.ID, r.Name)
+	return r, nil
 }
 
-func (g *Goomo) DeactivateSlam() {
-	// remove from matmux
-	g.matMux.Remove(slamMuxId)
-
-	// deactivate slam
-	if g.slam != nil {
-		if g.slam.Inbound != nil {
-			close(g.slam.Inbound)
-		}
-		g.slam.Inbound = nil
+func (g *Goomo) StopRecording() (Recording, error) {
+	l, err := g.Recordings()
+	if err != nil {
+		return Recording{}, err
 	}
-
-	g.slam = nil
+	g.videoLock.Lock()
+	defer g.videoLock.Unlock()
+	g.stopVideoCapture()
+	r, err := l.Stop()
+	if err == nil {
+		logger.Infof("Recording %s (%s) stopped", r.ID, r.Name)
+	}
+	return r, err
 }
 
 func (g *Goomo) TestSlam() {
 	chanMat := make(chan *ManagedMat)
 	slam := NewMonoSLAM(
-		"slam_lib/ORBvoc.bin",
-		"slam_lib/settings.yaml",
-		true,
-		false)
+		g.config.Slam.Vocabulary,
+		g.config.Slam.Settings,
+		g.config.Slam.Viewer,
+		g.config.Slam.SemiDense)
 	go slam.StartSlam(chanMat)
 
 	vc, err := gocv.VideoCaptureFile("/home/markus/Videos/test4.h264")
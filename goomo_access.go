@@ -0,0 +1,253 @@
+package goomo
+
+//goomo_access.go guards the mutating endpoints with bearer tokens and grants exclusive driving rights with a lease
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultLeaseDuration = 10 * time.Second
+
+var (
+	ErrLeaseHeld    = errors.New("control lease is held by another client")
+	ErrLeaseMissing = errors.New("acquire the control lease before driving")
+)
+
+// LeaseStatus is the response of /lease
+type LeaseStatus struct {
+	Held    bool       `json:"held"`
+	Holder  string     `json:"holder,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Yours   bool       `json:"yours"`
+}
+
+// Access maps tokens to client names. Reading is always allowed, PUT, POST and DELETE need a token and driving
+// needs the lease of the client in addition. Without tokens, which is the default, the clients are anonymous
+// and named by their remote address, so they need no token but still the lease to drive.
+type Access struct {
+	LeaseDuration time.Duration
+	// OnExpire is called when a lease times out without being released and OnRelease when it is released,
+	// e.g. to stop the robot
+	OnExpire  func(holder string)
+	OnRelease func(holder string)
+
+	lock    sync.Mutex
+	tokens  map[string]string
+	holder  string
+	expires time.Time
+	timer   *time.Timer
+}
+
+func NewAccess() *Access {
+	return &Access{
+		LeaseDuration: defaultLeaseDuration,
+		tokens:        make(map[string]string),
+	}
+}
+
+// AddToken allows the client to send mutating requests with "Authorization: Bearer <token>"
+func (a *Access) AddToken(token, client string) {
+	a.lock.Lock()
+	a.tokens[token] = client
+	a.lock.Unlock()
+}
+
+func (a *Access) Enabled() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.tokens) > 0
+}
+
+// client returns the name of the client whose token is sent as bearer or as access_token query parameter,
+// or the anonymous client of the remote address if no tokens are configured
+func (a *Access) client(r *http.Request) (string, bool) {
+	if !a.Enabled() {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return "anonymous@" + host, true
+	}
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return "", false
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	client, found := "", false
+	for t, c := range a.tokens {
+		// compare all tokens in constant time to not leak how much of a token matched
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			client, found = c, true
+		}
+	}
+	return client, found
+}
+
+// Acquire grants the lease to client or renews it if client already holds it. A lease which expired before
+// its timer fired is expired first, so OnExpire is called before Acquire returns.
+func (a *Access) Acquire(client string) (LeaseStatus, error) {
+	a.lock.Lock()
+	now := time.Now()
+	if a.holder != "" && a.holder != client && now.Before(a.expires) {
+		defer a.lock.Unlock()
+		return a.status(client), ErrLeaseHeld
+	}
+	expired, onExpire := "", a.OnExpire
+	if a.holder != "" && !now.Before(a.expires) {
+		expired = a.holder
+	}
+	a.holder = client
+	a.expires = now.Add(a.LeaseDuration)
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	expires := a.expires
+	a.timer = time.AfterFunc(a.LeaseDuration, func() {
+		a.expire(client, expires)
+	})
+	status := a.status(client)
+	a.lock.Unlock()
+	if expired != "" {
+		expiredLease(expired, onExpire)
+	}
+	return status, nil
+}
+
+func (a *Access) expire(client string, expires time.Time) {
+	a.lock.Lock()
+	if a.holder != client || !a.expires.Equal(expires) {
+		a.lock.Unlock()
+		return
+	}
+	a.holder = ""
+	onExpire := a.OnExpire
+	a.lock.Unlock()
+	expiredLease(client, onExpire)
+}
+
+func expiredLease(holder string, onExpire func(holder string)) {
+	logger.Infof("control lease of %s expired", holder)
+	if onExpire != nil {
+		onExpire(holder)
+	}
+}
+
+// Release gives up the lease, which is only possible for its holder
+func (a *Access) Release(client string) error {
+	a.lock.Lock()
+	if a.holder != client || time.Now().After(a.expires) {
+		a.lock.Unlock()
+		return ErrLeaseMissing
+	}
+	a.holder = ""
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	onRelease := a.OnRelease
+	a.lock.Unlock()
+	logger.Infof("control lease of %s released", client)
+	if onRelease != nil {
+		onRelease(client)
+	}
+	return nil
+}
+
+// Status returns the lease as seen by client
+func (a *Access) Status(client string) LeaseStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.status(client)
+}
+
+func (a *Access) status(client string) LeaseStatus {
+	if a.holder == "" || time.Now().After(a.expires) {
+		return LeaseStatus{}
+	}
+	expires := a.expires
+	return LeaseStatus{
+		Held:    true,
+		Holder:  a.holder,
+		Expires: &expires,
+		Yours:   client != "" && client == a.holder,
+	}
+}
+
+func (a *Access) checkLease(client string) error {
+	status := a.Status(client)
+	if status.Yours {
+		return nil
+	}
+	if status.Held {
+		return ErrLeaseHeld
+	}
+	return ErrLeaseMissing
+}
+
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// guard lets read-only requests pass and checks the token of all others, for driving also the lease
+func (a *Access) guard(h http.Handler, driving bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReadOnly(r.Method) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		client, ok := a.client(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="goomo"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		if driving {
+			if err := a.checkLease(client); err != nil {
+				writeAPIError(w, http.StatusConflict, err.Error())
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP returns the lease for GET, acquires or renews it for POST and releases it for DELETE
+func (a *Access) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, ok := a.client(r)
+	if !ok && r.Method != http.MethodGet {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="goomo"`)
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, a.Status(client))
+	case http.MethodPost:
+		status, err := a.Acquire(client)
+		if err != nil {
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case http.MethodDelete:
+		err := a.Release(client)
+		if err != nil {
+			writeAPIError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, a.Status(client))
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeAPIError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path)
+	}
+}
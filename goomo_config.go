@@ -0,0 +1,277 @@
+package goomo
+
+//goomo_config.go loads the settings which differ between rooms and robots from a JSON file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultConfigPath is loaded by NewGoomo if it exists, GOOMO_CONFIG selects another profile
+	DefaultConfigPath = "goomo.json"
+	configPathEnv     = "GOOMO_CONFIG"
+)
+
+// Duration is a time.Duration which is written as string in JSON, e.g. "500ms"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration has to be a string like \"2s\": %v", err)
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// LoomoConfig selects the Loomo, see LoomoCommunicator
+type LoomoConfig struct {
+	BroadcastPort    string   `json:"broadcastPort"`
+	Addr             string   `json:"addr,omitempty"`
+	Target           string   `json:"target,omitempty"`
+	DiscoveryTimeout Duration `json:"discoveryTimeout"`
+	CommandTimeout   Duration `json:"commandTimeout"`
+}
+
+type SlamConfig struct {
+	Vocabulary string `json:"vocabulary"`
+	Settings   string `json:"settings"`
+	Viewer     bool   `json:"viewer"`
+	SemiDense  bool   `json:"semiDense"`
+}
+
+// RecordingsConfig selects the directory of the RecordingLibrary and its quota in MiB
+type RecordingsConfig struct {
+	Dir     string `json:"dir"`
+	QuotaMB int64  `json:"quotaMB"`
+}
+
+// MotionConfig limits how fast the MotionShaper changes the velocities, the accelerations are in m/s² and rad/s²,
+// the jerks in m/s³ and rad/s³ and the control rate in Hz
+type MotionConfig struct {
+	LinearAcceleration  float32 `json:"linearAcceleration"`
+	AngularAcceleration float32 `json:"angularAcceleration"`
+	LinearJerk          float32 `json:"linearJerk"`
+	AngularJerk         float32 `json:"angularJerk"`
+	ControlRate         float64 `json:"controlRate"`
+}
+
+// WatchdogConfig contains the thresholds of the Watchdog per source
+type WatchdogConfig struct {
+	Frames   Duration `json:"frames"`
+	Features Duration `json:"features"`
+	AI       Duration `json:"ai"`
+}
+
+// Config contains everything which has to be tuned for a room or robot.
+// The defaults match the values which were used before there was a configuration.
+type Config struct {
+	Listen       string           `json:"listen"`
+	CORSOrigins  []string         `json:"corsOrigins"`
+	CameraPort   string           `json:"cameraPort"`
+	Loomo        LoomoConfig      `json:"loomo"`
+	Postits      []HSVDescription `json:"postits"`
+	TrafficSigns []HSVDescription `json:"trafficSigns"`
+	// TrafficSignNN is the directory of the saved model
+	TrafficSignNN string `json:"trafficSignNN"`
+	// MaxLv in m/s and MaxAv in rad/s limit the velocities of the MovementAI and of the clients, see MotionShaper
+	MaxLv  float32      `json:"maxLv"`
+	MaxAv  float32      `json:"maxAv"`
+	Motion MotionConfig `json:"motion"`
+	// EyeHeight is the height of the camera in cm, which is needed by the DistanceLookup
+	EyeHeight float64    `json:"eyeHeight"`
+	Slam      SlamConfig `json:"slam"`
+	// Tokens maps tokens to client names, see Access
+	Tokens        map[string]string `json:"tokens,omitempty"`
+	LeaseDuration Duration          `json:"leaseDuration"`
+	// TeleopTimeout is the time after which the Loomo is stopped if /ws/teleop receives no twist
+	TeleopTimeout Duration         `json:"teleopTimeout"`
+	Recordings    RecordingsConfig `json:"recordings"`
+	// ShutdownTimeout is the deadline for stopping the Loomo, the modules and the HTTP endpoints
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// Watchdog stops the Loomo if a source of the AIs stalls for longer than its threshold
+	Watchdog WatchdogConfig `json:"watchdog"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:      ":4000",
+		CORSOrigins: []string{"http://localhost:4200"},
+		CameraPort:  defaultCameraPort,
+		Loomo: LoomoConfig{
+			BroadcastPort:    ":1336",
+			DiscoveryTimeout: Duration{defaultDiscoveryTimeout},
+			CommandTimeout:   Duration{2 * time.Second},
+		},
+		Postits:       NewColorTracker(),
+		TrafficSigns:  NewTrafficSignDescription(),
+		TrafficSignNN: defaultTrafficSignNN,
+		MaxLv:         0.4,
+		MaxAv:         0.4,
+		Motion: MotionConfig{
+			LinearAcceleration:  defaultLinearAcceleration,
+			AngularAcceleration: defaultAngularAcceleration,
+			LinearJerk:          defaultLinearJerk,
+			AngularJerk:         defaultAngularJerk,
+			ControlRate:         defaultControlRate,
+		},
+		EyeHeight: defaultEyeHeight,
+		Slam: SlamConfig{
+			Vocabulary: "slam_lib/ORBvoc.bin",
+			Settings:   "slam_lib/settings.yaml",
+			Viewer:     true,
+		},
+		LeaseDuration: Duration{defaultLeaseDuration},
+		TeleopTimeout: Duration{defaultTeleopTimeout},
+		Recordings: RecordingsConfig{
+			Dir:     defaultRecordingsDir,
+			QuotaMB: defaultRecordingQuotaMB,
+		},
+		ShutdownTimeout: Duration{defaultShutdownTimeout},
+		Watchdog: WatchdogConfig{
+			Frames:   Duration{defaultWatchdogThreshold},
+			Features: Duration{defaultWatchdogThreshold},
+			AI:       Duration{defaultWatchdogThreshold},
+		},
+	}
+}
+
+// LoadConfig reads the JSON file at path, fields which are missing keep their default value
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening config: %v", err)
+	}
+	defer f.Close()
+
+	config := DefaultConfig()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("decoding config %s: %v", path, err)
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// configPath returns the file named by GOOMO_CONFIG or DefaultConfigPath if it exists
+func configPath() string {
+	if path := os.Getenv(configPathEnv); path != "" {
+		return path
+	}
+	if _, err := os.Stat(DefaultConfigPath); err == nil {
+		return DefaultConfigPath
+	}
+	return ""
+}
+
+func validPortString(name, port string) error {
+	p, err := strconv.Atoi(strings.TrimPrefix(port, ":"))
+	if err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("%s %q is not a port", name, port)
+	}
+	return nil
+}
+
+func validHSV(name string, descriptions []HSVDescription) error {
+	if len(descriptions) == 0 {
+		return fmt.Errorf("%s needs at least one color", name)
+	}
+	for i, d := range descriptions {
+		if d.H < 0 || d.H > 360 || d.HB < 0 || d.HB > 180 {
+			return fmt.Errorf("%s[%d]: hue has to be within [0, 360] and its boundary within [0, 180]", name, i)
+		}
+		for _, v := range []float64{d.S, d.V, d.SB, d.VB} {
+			if v < 0 || v > 100 {
+				return fmt.Errorf("%s[%d]: saturation, value and their boundaries have to be within [0, 100]", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address is missing")
+	}
+	err := validPortString("cameraPort", c.CameraPort)
+	if err != nil {
+		return err
+	}
+	err = validPortString("loomo.broadcastPort", c.Loomo.BroadcastPort)
+	if err != nil {
+		return err
+	}
+	if c.Loomo.DiscoveryTimeout.Duration < 0 || c.Loomo.CommandTimeout.Duration <= 0 {
+		return fmt.Errorf("loomo.discoveryTimeout must not be negative and loomo.commandTimeout has to be positive")
+	}
+	err = validHSV("postits", c.Postits)
+	if err != nil {
+		return err
+	}
+	err = validHSV("trafficSigns", c.TrafficSigns)
+	if err != nil {
+		return err
+	}
+	if c.TrafficSignNN == "" {
+		return fmt.Errorf("trafficSignNN is missing")
+	}
+	if c.MaxLv <= 0 || c.MaxAv <= 0 {
+		return fmt.Errorf("maxLv and maxAv have to be positive")
+	}
+	m := c.Motion
+	if m.LinearAcceleration <= 0 || m.AngularAcceleration <= 0 || m.LinearJerk <= 0 || m.AngularJerk <= 0 {
+		return fmt.Errorf("the accelerations and jerks of motion have to be positive")
+	}
+	if m.ControlRate <= 0 || m.ControlRate > maxControlRate {
+		return fmt.Errorf("motion.controlRate has to be within (0, %d] Hz", maxControlRate)
+	}
+	if c.EyeHeight <= 0 {
+		return fmt.Errorf("eyeHeight has to be positive")
+	}
+	if c.Slam.Vocabulary == "" || c.Slam.Settings == "" {
+		return fmt.Errorf("slam.vocabulary and slam.settings are needed")
+	}
+	for token, client := range c.Tokens {
+		if token == "" || client == "" {
+			return fmt.Errorf("tokens and client names must not be empty")
+		}
+	}
+	if c.LeaseDuration.Duration <= 0 || c.TeleopTimeout.Duration <= 0 || c.ShutdownTimeout.Duration <= 0 {
+		return fmt.Errorf("leaseDuration, teleopTimeout and shutdownTimeout have to be positive")
+	}
+	if c.Recordings.Dir == "" || c.Recordings.QuotaMB <= 0 {
+		return fmt.Errorf("recordings.dir is needed and recordings.quotaMB has to be positive")
+	}
+	if c.Watchdog.Frames.Duration <= 0 || c.Watchdog.Features.Duration <= 0 || c.Watchdog.AI.Duration <= 0 {
+		return fmt.Errorf("watchdog.frames, watchdog.features and watchdog.ai have to be positive")
+	}
+	return nil
+}
+
+// newLoomoCommunicator creates a LoomoCommunicator with the discovery settings of the config
+func (c *Config) newLoomoCommunicator() *LoomoCommunicator {
+	lc := NewLoomoCommunicator()
+	lc.BCport = c.Loomo.BroadcastPort
+	lc.Addr = c.Loomo.Addr
+	lc.Target = c.Loomo.Target
+	lc.DiscoveryTimeout = c.Loomo.DiscoveryTimeout.Duration
+	lc.CommandTimeout = c.Loomo.CommandTimeout.Duration
+	return lc
+}
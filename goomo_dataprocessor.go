@@ -3,29 +3,46 @@ package goomo
 import (
 	"gocv.io/x/gocv"
 	"sync"
+	"time"
 )
 
 type DataProcessor struct {
 	InboundData chan *LoomoData
 	OutboundJPG chan JPG
 	OutboundMat chan *ManagedMat
+	Screen      *DebugScreen
+	// Watchdog receives a beat of WatchFrames for every decoded frame, it is optional
+	Watchdog *Watchdog
+	metrics  *moduleMetrics
+}
+
+func (d *DataProcessor) Stream() string {
+	return CameraStream
 }
 
 func (d *DataProcessor) HandleStream(stream *SensorStream, _ chan Command) {
 	logger.Debug("DataProcessor started.")
 	d.InboundData = stream.Data
 	id := int64(0)
+	metrics := d.metrics.get()
 	for loomoData := range d.InboundData {
 		//logger.Debug("dp")
+		metrics.dpFrames.With().Inc()
+		start := time.Now()
 		mat, err := gocv.IMDecode(loomoData.data, gocv.IMReadColor)
+		metrics.dpDecodeSeconds.With().Since(start)
 		if err != nil {
+			metrics.dpDecodeErrors.With().Inc()
 			logger.Error("failed to decode image", "error", err)
+		} else {
+			d.Watchdog.Beat(WatchFrames)
 		}
 
 		managed := (&ManagedMat{
 			id:        id,
 			timestamp: loomoData.timestamp,
 			lock:      &sync.Mutex{},
+			screen:    d.Screen,
 		}).Init(&mat)
 
 		id++
@@ -34,7 +51,7 @@ func (d *DataProcessor) HandleStream(stream *SensorStream, _ chan Command) {
 		case d.OutboundMat <- managed:
 
 		default:
-			//nothing to do
+			metrics.dpDropped.With("mat").Inc()
 			managed.Done()
 		}
 		go managed.Finish()
@@ -42,6 +59,7 @@ func (d *DataProcessor) HandleStream(stream *SensorStream, _ chan Command) {
 		select {
 		case d.OutboundJPG <- JPG(loomoData.data):
 		default:
+			metrics.dpDropped.With("jpg").Inc()
 		}
 	}
 	logger.Debug("DataProcessor stopped.")
@@ -0,0 +1,209 @@
+package goomo
+
+//goomo_debugscreen.go serves the camera images with the drawings of the modules as MJPEG stream and single snapshots
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// Layers which the modules draw on the debug frames
+const (
+	LayerContours     = "contours"
+	LayerFeatures     = "features"
+	LayerTrafficSigns = "trafficsigns"
+)
+
+var debugLayers = []string{LayerContours, LayerFeatures, LayerTrafficSigns}
+
+// DebugScreen keeps the last camera image of one Goomo with the drawings of all modules.
+// While it is active, the annotated images are encoded for the clients of /stream/debug,
+// one stream per selection of layers.
+type DebugScreen struct {
+	name      string
+	lock      sync.Mutex
+	active    bool
+	streams   map[string]*MJPGStream
+	last      *gocv.Mat
+	lastID    int64
+	functions []layeredFunction
+}
+
+func NewDebugScreen(name string) *DebugScreen {
+	return &DebugScreen{
+		name:    name,
+		streams: make(map[string]*MJPGStream),
+	}
+}
+
+func (d *DebugScreen) IsActive() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.active
+}
+
+// DebugScreen is the Module debug-screen, snapshots are served while it is inactive too
+func (d *DebugScreen) ID() string             { return DebugScreenModule }
+func (d *DebugScreen) Dependencies() []string { return nil }
+func (d *DebugScreen) Conflicts() []string    { return nil }
+
+func (d *DebugScreen) Status() ModuleStatus {
+	return ModuleStatus{Active: d.IsActive()}
+}
+
+func (d *DebugScreen) Activate() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.active = true
+	return nil
+}
+
+func (d *DebugScreen) Deactivate() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.active = false
+	return nil
+}
+
+// parseLayers returns the selected layers and a key which is equal for equal selections, an empty query selects all
+func parseLayers(query string) (map[string]bool, string, error) {
+	if query == "" {
+		query = strings.Join(debugLayers, ",")
+	}
+	layers := make(map[string]bool)
+	for _, layer := range strings.Split(query, ",") {
+		known := false
+		for _, l := range debugLayers {
+			known = known || l == layer
+		}
+		if !known {
+			return nil, "", fmt.Errorf("unknown layer %q, known are %s", layer, strings.Join(debugLayers, ", "))
+		}
+		layers[layer] = true
+	}
+	keys := make([]string, 0, len(layers))
+	for layer := range layers {
+		keys = append(keys, layer)
+	}
+	sort.Strings(keys)
+	return layers, strings.Join(keys, ","), nil
+}
+
+// show keeps a copy of the frame for snapshots and sends it to the watched streams, mm is closed afterwards
+func (d *DebugScreen) show(mm *ManagedMat) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	// frames are finished concurrently, so an older one may arrive late
+	if d.last != nil && mm.id < d.lastID {
+		return
+	}
+	if d.last != nil {
+		d.last.Close()
+	}
+	last := mm.mat.Clone()
+	d.last = &last
+	d.lastID = mm.id
+	d.functions = mm.layers()
+
+	if !d.active {
+		return
+	}
+	for key, stream := range d.streams {
+		if stream.NWatch() == 0 {
+			continue
+		}
+		layers, _, _ := parseLayers(key)
+		buf, err := d.encode(layers)
+		if err != nil {
+			logger.Errorf("encoding debug frame: %v", err)
+			return
+		}
+		err = stream.Update(buf)
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// encode draws the layers on a copy of the last frame, without layers it is encoded raw. It has to be called with lock held.
+func (d *DebugScreen) encode(layers map[string]bool) ([]byte, error) {
+	mat := d.last.Clone()
+	defer mat.Close()
+	for i := len(d.functions) - 1; i >= 0; i-- {
+		if layers[d.functions[i].layer] {
+			d.functions[i].draw(&mat)
+		}
+	}
+	return gocv.IMEncode(".jpg", mat)
+}
+
+func (d *DebugScreen) stream(key string) *MJPGStream {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	stream, ok := d.streams[key]
+	if !ok {
+		stream = NewStream()
+		d.streams[key] = stream
+	}
+	return stream
+}
+
+// ServeHTTP streams the annotated frames while the debug-screen is active, the query parameter layers=contours,features selects the drawings, default are all
+func (d *DebugScreen) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, key, err := parseLayers(r.URL.Query().Get("layers"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !d.IsActive() {
+		writeAPIError(w, http.StatusConflict, "activate the debug-screen in /settings first")
+		return
+	}
+	d.stream(key).ServeHTTP(w, r)
+}
+
+// Snapshot responds with the last frame as JPG, raw by default.
+// annotated=true draws all layers, layers=trafficsigns draws only the selected ones.
+func (d *DebugScreen) Snapshot(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	annotated := query.Get("layers") != ""
+	if a := query.Get("annotated"); a != "" {
+		var err error
+		annotated, err = strconv.ParseBool(a)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "annotated has to be true or false")
+			return
+		}
+	}
+	layers := map[string]bool{}
+	if annotated {
+		var err error
+		layers, _, err = parseLayers(query.Get("layers"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	d.lock.Lock()
+	if d.last == nil {
+		d.lock.Unlock()
+		writeAPIError(w, http.StatusServiceUnavailable, "no camera frame received yet")
+		return
+	}
+	buf, err := d.encode(layers)
+	d.lock.Unlock()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("encoding snapshot: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf)
+}
@@ -0,0 +1,173 @@
+package goomo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Fleet runs several Goomos in one process, each with its own LoomoCommunicator, muxes, trackers, AI and config.
+// Their endpoints are served below /robots/{id}.
+type Fleet struct {
+	lock   sync.Mutex
+	robots map[string]*Goomo
+	router *mux.Router
+	// config selects the listen address, the CORS origins and the shutdown timeout of the HTTP endpoints
+	config *Config
+	// ctx ends the HTTP endpoints after all robots were shut down
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewFleet() *Fleet {
+	return NewFleetWithConfig(DefaultConfig())
+}
+
+// NewFleetWithConfig serves the endpoints of the robots as configured by config, the robots have their own configs
+func NewFleetWithConfig(config *Config) *Fleet {
+	f := &Fleet{
+		robots: make(map[string]*Goomo),
+		config: config,
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.router = mux.NewRouter()
+	f.router.HandleFunc("/robots", f.serveRobots)
+	f.router.HandleFunc("/metrics", f.serveMetrics)
+	f.router.PathPrefix("/robots/{id}/").HandlerFunc(f.serveRobot)
+	return f
+}
+
+// Add registers a Goomo under id, it has to be started separately or with Start
+func (f *Fleet) Add(id string, g *Goomo) error {
+	if id == "" || strings.Contains(id, "/") {
+		return fmt.Errorf("invalid robot id %q", id)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.robots[id]; ok {
+		return fmt.Errorf("robot %q already exists", id)
+	}
+	for otherId, other := range f.robots {
+		for _, stream := range other.sensors.Streams() {
+			otherPort, _ := other.sensors.Port(stream)
+			for _, s := range g.sensors.Streams() {
+				if port, _ := g.sensors.Port(s); port == otherPort {
+					return fmt.Errorf("robot %q already receives its stream %s on port %s", otherId, stream, port)
+				}
+			}
+		}
+	}
+	g.screen.name = "Debug Screen " + id
+	f.robots[id] = g
+	return nil
+}
+
+func (f *Fleet) Get(id string) (*Goomo, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	g, ok := f.robots[id]
+	return g, ok
+}
+
+func (f *Fleet) Remove(id string) {
+	f.lock.Lock()
+	delete(f.robots, id)
+	f.lock.Unlock()
+}
+
+// IDs returns the ids of all robots in lexical order
+func (f *Fleet) IDs() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ids := make([]string, 0, len(f.robots))
+	for id := range f.robots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Start starts every robot of the fleet, they are shut down in parallel when ctx is done
+func (f *Fleet) Start(ctx context.Context) {
+	for _, id := range f.IDs() {
+		if g, ok := f.Get(id); ok {
+			g.Start(ctx)
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		f.Wait()
+		f.cancel()
+	}()
+}
+
+// Wait blocks until every robot of the fleet was shut down
+func (f *Fleet) Wait() {
+	for _, id := range f.IDs() {
+		if g, ok := f.Get(id); ok {
+			g.Wait()
+		}
+	}
+}
+
+func (f *Fleet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.router.ServeHTTP(w, r)
+}
+
+func (f *Fleet) ActivateHTTPEndpoints() {
+	listenAndServe(f.ctx, f.config.ShutdownTimeout.Duration, f.config.Listen, f.config.CORSOrigins, f)
+}
+
+type robotStatus struct {
+	ID         string          `json:"id"`
+	Connection ConnectionEvent `json:"connection"`
+}
+
+func (f *Fleet) serveRobots(w http.ResponseWriter, r *http.Request) {
+	response := make([]robotStatus, 0)
+	for _, id := range f.IDs() {
+		if g, ok := f.Get(id); ok {
+			response = append(response, robotStatus{ID: id, Connection: g.lc.ConnectionStatus()})
+		}
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+// serveMetrics exports the metrics of all robots with their id as label robot
+func (f *Fleet) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	registries := make(map[string]*MetricsRegistry)
+	for _, id := range f.IDs() {
+		if g, ok := f.Get(id); ok {
+			registries[id] = g.Metrics()
+		}
+	}
+	serveMetrics(w, func(w io.Writer) {
+		ExportLabeled(w, "robot", registries)
+	})
+}
+
+// serveRobot passes /robots/{id}/... as /... to the router of the robot
+func (f *Fleet) serveRobot(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	g, ok := f.Get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown robot %q", id), http.StatusNotFound)
+		return
+	}
+	http.StripPrefix("/robots/"+id, g.Handler()).ServeHTTP(w, r)
+}
@@ -0,0 +1,427 @@
+package goomo
+
+//goomo_metrics.go counts what the modules do and serves it in the Prometheus text format at /metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// processMetrics count the modules which were created without a Goomo, every Goomo has metrics of its own,
+// so the robots of a Fleet are counted separately
+var processMetrics = newModuleMetrics(NewMetricsRegistry())
+
+// Metrics returns the registry of the modules which were created without a Goomo, see Goomo.Metrics
+func Metrics() *MetricsRegistry {
+	return processMetrics.registry
+}
+
+// defaultBuckets are upper bounds in seconds, from below a millisecond to a second
+var defaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// moduleMetrics are the metrics of the modules of one Goomo, the labels are listed after the help
+type moduleMetrics struct {
+	registry *MetricsRegistry
+
+	lcCommands       *CounterVec
+	lcCommandSeconds *HistogramVec
+	lcCoalesced      *CounterVec
+	lcRejected       *CounterVec
+	lcQueueLength    *GaugeVec
+	lcFrames         *CounterVec
+
+	dpFrames        *CounterVec
+	dpDropped       *CounterVec
+	dpDecodeErrors  *CounterVec
+	dpDecodeSeconds *HistogramVec
+
+	muxFrames  *CounterVec
+	muxSkipped *CounterVec
+
+	trackerSeconds  *HistogramVec
+	trackerFeatures *CounterVec
+
+	nnSeconds     *HistogramVec
+	nnPredictions *CounterVec
+
+	aiInputs    *CounterVec
+	aiStates    *CounterVec
+	slamSeconds *HistogramVec
+	slamFrames  *CounterVec
+
+	watchdogFaults *CounterVec
+	motionClamped  *CounterVec
+}
+
+func newModuleMetrics(r *MetricsRegistry) *moduleMetrics {
+	return &moduleMetrics{
+		registry: r,
+
+		lcCommands:       r.NewCounterVec("goomo_lc_commands_total", "Commands written to the Loomo by tag and result", "tag", "result"),
+		lcCommandSeconds: r.NewHistogramVec("goomo_lc_command_write_seconds", "Time to write a command to the Loomo", defaultBuckets),
+		lcCoalesced:      r.NewCounterVec("goomo_lc_commands_coalesced_total", "Queued velocity commands replaced by a newer one"),
+		lcRejected:       r.NewCounterVec("goomo_lc_commands_rejected_total", "Commands rejected because the queue was full"),
+		lcQueueLength:    r.NewGaugeVec("goomo_lc_command_queue_length", "Commands waiting in the queue", "queue"),
+		lcFrames:         r.NewCounterVec("goomo_lc_frames_total", "Reassembled frames by stream", "stream"),
+
+		dpFrames:        r.NewCounterVec("goomo_dp_frames_total", "Camera frames received by the DataProcessor"),
+		dpDropped:       r.NewCounterVec("goomo_dp_frames_dropped_total", "Camera frames not taken by the multiplexer by output", "output"),
+		dpDecodeErrors:  r.NewCounterVec("goomo_dp_decode_errors_total", "Camera frames which could not be decoded"),
+		dpDecodeSeconds: r.NewHistogramVec("goomo_dp_decode_seconds", "Time of IMDecode per camera frame", defaultBuckets),
+
+		muxFrames:  r.NewCounterVec("goomo_mux_frames_total", "Frames passed to a receiver by multiplexer and receiver ID", "mux", "receiver"),
+		muxSkipped: r.NewCounterVec("goomo_mux_frames_skipped_total", "Frames skipped because the receiver was busy by multiplexer and receiver ID", "mux", "receiver"),
+
+		trackerSeconds:  r.NewHistogramVec("goomo_tracker_seconds", "Processing time per frame by tracker", defaultBuckets, "tracker"),
+		trackerFeatures: r.NewCounterVec("goomo_tracker_features_total", "Detected features by tracker", "tracker"),
+
+		nnSeconds:     r.NewHistogramVec("goomo_nn_predict_seconds", "Latency of TrafficSignNN.PredictWithCertainty", defaultBuckets),
+		nnPredictions: r.NewCounterVec("goomo_nn_predictions_total", "Predictions of the TrafficSignNN by sign, errors have the sign \"error\"", "sign"),
+
+		aiInputs:    r.NewCounterVec("goomo_ai_inputs_total", "Detections handled by the MovementAI by input", "input"),
+		aiStates:    r.NewCounterVec("goomo_ai_state_changes_total", "Transitions of the MovementAI by new state", "state"),
+		slamSeconds: r.NewHistogramVec("goomo_slam_track_seconds", "Time of MonoSLAM.Track per frame", []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5}),
+		slamFrames:  r.NewCounterVec("goomo_slam_frames_total", "Frames tracked by SLAM by tracking state afterwards", "state"),
+
+		watchdogFaults: r.NewCounterVec("goomo_watchdog_faults_total", "Faults latched by the Watchdog by stale source", "source"),
+		motionClamped:  r.NewCounterVec("goomo_motion_clamped_total", "Target velocities clamped to the limits by the MotionShaper by axis", "axis"),
+	}
+}
+
+// get returns m, or the metrics of the process for modules which were created without a Goomo
+func (m *moduleMetrics) get() *moduleMetrics {
+	if m == nil {
+		return processMetrics
+	}
+	return m
+}
+
+type metricFamily interface {
+	family() *metricVec
+	// writeSamples writes the series, extra labels like robot="alpha" are put in front of their own
+	writeSamples(w io.Writer, extra string)
+}
+
+// MetricsRegistry keeps metric families in the order they were created
+type MetricsRegistry struct {
+	lock     sync.Mutex
+	families []metricFamily
+	names    map[string]bool
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{names: make(map[string]bool)}
+}
+
+func (r *MetricsRegistry) register(name string, f metricFamily) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		panic("metric " + name + " is registered twice")
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// withoutLabels creates the only series of families without labels, so they are exported from the start
+func withoutLabels(v *metricVec, create func()) {
+	if len(v.labels) == 0 {
+		create()
+	}
+}
+
+// metricVec holds the series of one family by their label values
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	lock   sync.Mutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func newMetricVec(name, help, kind string, labels []string) *metricVec {
+	return &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]interface{}),
+		values: make(map[string][]string),
+	}
+}
+
+// get returns the series for the label values and creates it with create if it is new
+func (v *metricVec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s needs %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+func (v *metricVec) family() *metricVec {
+	return v
+}
+
+func (v *metricVec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+func (v *metricVec) writeSeries(w io.Writer, extra string, write func(w io.Writer, labels string, s interface{})) {
+	v.lock.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]interface{}, len(keys))
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		series[i] = v.series[key]
+		labels[i] = formatLabels(v.labels, v.values[key])
+		if extra != "" && labels[i] != "" {
+			labels[i] = extra + "," + labels[i]
+		} else if extra != "" {
+			labels[i] = extra
+		}
+	}
+	v.lock.Unlock()
+	for i := range series {
+		write(w, labels[i], series[i])
+	}
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func withLabels(name, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter only increases
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+type CounterVec struct {
+	*metricVec
+}
+
+func (r *MetricsRegistry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newMetricVec(name, help, "counter", labels)}
+	r.register(name, v)
+	withoutLabels(v.metricVec, func() { v.With() })
+	return v
+}
+
+// With returns the counter of the label values, which are given in the order of the labels
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (v *CounterVec) writeSamples(w io.Writer, extra string) {
+	v.metricVec.writeSeries(w, extra, func(w io.Writer, labels string, s interface{}) {
+		fmt.Fprintf(w, "%s %d\n", withLabels(v.name, labels), atomic.LoadUint64(&s.(*Counter).v))
+	})
+}
+
+// Gauge is a value which goes up and down
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+type GaugeVec struct {
+	*metricVec
+}
+
+func (r *MetricsRegistry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newMetricVec(name, help, "gauge", labels)}
+	r.register(name, v)
+	withoutLabels(v.metricVec, func() { v.With() })
+	return v
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.get(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (v *GaugeVec) writeSamples(w io.Writer, extra string) {
+	v.metricVec.writeSeries(w, extra, func(w io.Writer, labels string, s interface{}) {
+		fmt.Fprintf(w, "%s %s\n", withLabels(v.name, labels), formatValue(s.(*Gauge).Value()))
+	})
+}
+
+// Histogram counts observations in buckets of upper bounds
+type Histogram struct {
+	lock    sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Since observes the seconds since start, e.g. `defer h.Since(time.Now())`
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+type HistogramVec struct {
+	*metricVec
+	bounds []float64
+}
+
+func (r *MetricsRegistry) NewHistogramVec(name, help string, bounds []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{newMetricVec(name, help, "histogram", labels), bounds}
+	r.register(name, v)
+	withoutLabels(v.metricVec, func() { v.With() })
+	return v
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.get(values, func() interface{} {
+		return &Histogram{bounds: v.bounds, buckets: make([]uint64, len(v.bounds))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) writeSamples(w io.Writer, extra string) {
+	v.metricVec.writeSeries(w, extra, func(w io.Writer, labels string, s interface{}) {
+		h := s.(*Histogram)
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", v.name, labels, sep, formatValue(bound), h.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", v.name, labels, sep, h.count)
+		fmt.Fprintf(w, "%s %s\n", withLabels(v.name+"_sum", labels), formatValue(h.sum))
+		fmt.Fprintf(w, "%s %d\n", withLabels(v.name+"_count", labels), h.count)
+	})
+}
+
+func (r *MetricsRegistry) copyFamilies() []metricFamily {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]metricFamily(nil), r.families...)
+}
+
+// Export writes all metrics in the Prometheus text format
+func (r *MetricsRegistry) Export(w io.Writer) {
+	for _, f := range r.copyFamilies() {
+		f.family().writeHeader(w)
+		f.writeSamples(w, "")
+	}
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	serveMetrics(w, r.Export)
+}
+
+// ExportLabeled writes the metrics of several registries, e.g. of the robots of a Fleet, with the label name
+// set to their key. The series of the families with the same name are written below one header.
+func ExportLabeled(w io.Writer, name string, registries map[string]*MetricsRegistry) {
+	keys := make([]string, 0, len(registries))
+	for key := range registries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var names []string
+	families := make(map[string][]metricFamily)
+	labels := make(map[metricFamily]string)
+	for _, key := range keys {
+		for _, f := range registries[key].copyFamilies() {
+			familyName := f.family().name
+			if _, ok := families[familyName]; !ok {
+				names = append(names, familyName)
+			}
+			families[familyName] = append(families[familyName], f)
+			labels[f] = formatLabels([]string{name}, []string{key})
+		}
+	}
+	for _, familyName := range names {
+		fs := families[familyName]
+		fs[0].family().writeHeader(w)
+		for _, f := range fs {
+			f.writeSamples(w, labels[f])
+		}
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, export func(w io.Writer)) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf := bufio.NewWriter(w)
+	export(buf)
+	buf.Flush()
+}
@@ -0,0 +1,511 @@
+package goomo
+
+//goomo_modules.go keeps the processing modules which are switched on and off through /settings
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// IDs of the built-in modules, they are the keys of /settings
+const (
+	DebugScreenModule   = "debug-screen"
+	PostitAIModule      = "postit-ai"
+	TrafficSignAIModule = "trafficsign-ai"
+	SlamModule          = "slam"
+	VideoCaptureModule  = "video-capture"
+)
+
+// Module is a processing module of a Goomo which can be activated and deactivated at runtime.
+// Dependencies have to be active while the module is, Conflicts must not.
+type Module interface {
+	ID() string
+	Dependencies() []string
+	Conflicts() []string
+	Activate() error
+	Deactivate() error
+	Status() ModuleStatus
+}
+
+// ModuleStatus is reported by a module, Message is optional, e.g. the running recording
+type ModuleStatus struct {
+	Active  bool   `json:"active"`
+	Message string `json:"message,omitempty"`
+}
+
+// ModuleInfo is an element of the response of /modules
+type ModuleInfo struct {
+	ID           string   `json:"id"`
+	Dependencies []string `json:"dependencies"`
+	Conflicts    []string `json:"conflicts"`
+	ModuleStatus
+}
+
+// ModuleError rejects an update of the modules before any of them was switched
+type ModuleError struct {
+	Module string
+	// Unknown is set if Module is not registered
+	Unknown bool
+	Reason  string
+}
+
+func (e *ModuleError) Error() string {
+	return e.Module + ": " + e.Reason
+}
+
+// ModuleRegistry switches the modules of a Goomo and enforces their dependencies and conflicts.
+// Modules are activated in the order they were registered and deactivated in reverse.
+type ModuleRegistry struct {
+	lock    sync.Mutex
+	modules []Module
+	byID    map[string]Module
+}
+
+func NewModuleRegistry() *ModuleRegistry {
+	return &ModuleRegistry{byID: make(map[string]Module)}
+}
+
+// Register adds a module, its dependencies have to be registered before
+func (r *ModuleRegistry) Register(m Module) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.byID[m.ID()]; ok {
+		return fmt.Errorf("module %s is already registered", m.ID())
+	}
+	for _, dependency := range m.Dependencies() {
+		if _, ok := r.byID[dependency]; !ok {
+			return fmt.Errorf("module %s depends on %s, which is not registered", m.ID(), dependency)
+		}
+	}
+	r.modules = append(r.modules, m)
+	r.byID[m.ID()] = m
+	return nil
+}
+
+// IDs returns the ids of all modules in the order they were registered
+func (r *ModuleRegistry) IDs() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.ids()
+}
+
+// States returns whether each module is active
+func (r *ModuleRegistry) States() map[string]bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.states()
+}
+
+// states has to be called with lock held
+func (r *ModuleRegistry) states() map[string]bool {
+	states := make(map[string]bool, len(r.modules))
+	for _, m := range r.modules {
+		states[m.ID()] = m.Status().Active
+	}
+	return states
+}
+
+// Active returns whether the module is registered and active
+func (r *ModuleRegistry) Active(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	m, ok := r.byID[id]
+	return ok && m.Status().Active
+}
+
+// List describes all modules in the order they were registered
+func (r *ModuleRegistry) List() []ModuleInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	infos := make([]ModuleInfo, len(r.modules))
+	for i, m := range r.modules {
+		infos[i] = ModuleInfo{
+			ID:           m.ID(),
+			Dependencies: append([]string{}, m.Dependencies()...),
+			Conflicts:    append([]string{}, m.Conflicts()...),
+			ModuleStatus: m.Status(),
+		}
+	}
+	return infos
+}
+
+func (r *ModuleRegistry) Activate(id string) error {
+	_, err := r.Apply(map[string]bool{id: true})
+	return err
+}
+
+func (r *ModuleRegistry) Deactivate(id string) error {
+	_, err := r.Apply(map[string]bool{id: false})
+	return err
+}
+
+// Apply switches the modules of update and returns their states afterwards.
+// The update is rejected with a ModuleError if a module is unknown or the result would
+// leave a dependency inactive or two conflicting modules active.
+func (r *ModuleRegistry) Apply(update map[string]bool) (map[string]bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	current := r.states()
+	target := make(map[string]bool, len(current))
+	for id, active := range current {
+		target[id] = active
+	}
+	for id, active := range update {
+		if _, ok := r.byID[id]; !ok {
+			return nil, &ModuleError{Module: id, Unknown: true, Reason: "unknown module, known are " + strings.Join(r.ids(), ", ")}
+		}
+		target[id] = active
+	}
+	err := r.check(target)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(update))
+	for i := len(r.modules) - 1; i >= 0; i-- {
+		m := r.modules[i]
+		if current[m.ID()] && !target[m.ID()] {
+			err = m.Deactivate()
+			if err != nil {
+				return r.result(update, result), fmt.Errorf("deactivating %s: %v", m.ID(), err)
+			}
+		}
+	}
+	for _, m := range r.modules {
+		if !current[m.ID()] && target[m.ID()] {
+			err = m.Activate()
+			if err != nil {
+				return r.result(update, result), fmt.Errorf("activating %s: %v", m.ID(), err)
+			}
+		}
+	}
+	return r.result(update, result), nil
+}
+
+// DeactivateAll deactivates every active module in reverse order, e.g. on shutdown.
+// Dependencies and conflicts cannot be violated, a failing module does not stop the others.
+func (r *ModuleRegistry) DeactivateAll() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var failed []string
+	for i := len(r.modules) - 1; i >= 0; i-- {
+		m := r.modules[i]
+		if !m.Status().Active {
+			continue
+		}
+		err := m.Deactivate()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("deactivating %s: %v", m.ID(), err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// ids has to be called with lock held
+func (r *ModuleRegistry) ids() []string {
+	ids := make([]string, len(r.modules))
+	for i, m := range r.modules {
+		ids[i] = m.ID()
+	}
+	return ids
+}
+
+// check returns the first dependency or conflict which target violates, conflicts count in both directions
+func (r *ModuleRegistry) check(target map[string]bool) error {
+	for _, m := range r.modules {
+		if !target[m.ID()] {
+			continue
+		}
+		for _, dependency := range m.Dependencies() {
+			if !target[dependency] {
+				return &ModuleError{Module: m.ID(), Reason: "needs " + dependency + " to be active"}
+			}
+		}
+		for _, conflict := range m.Conflicts() {
+			if target[conflict] {
+				return &ModuleError{Module: m.ID(), Reason: "cannot be active together with " + conflict}
+			}
+		}
+	}
+	return nil
+}
+
+// result has to be called with lock held, it fills result with the states of the modules of update
+func (r *ModuleRegistry) result(update, result map[string]bool) map[string]bool {
+	for id := range update {
+		result[id] = r.byID[id].Status().Active
+	}
+	return result
+}
+
+// registerModules adds the built-in modules, the AIs share the MovementAI of the Goomo
+func (g *Goomo) registerModules() {
+	for _, m := range []Module{
+		g.screen,
+		&postitAI{g: g},
+		&trafficSignAI{g: g},
+		&slamModule{g: g},
+		&videoCapture{g: g},
+	} {
+		err := g.modules.Register(m)
+		if err != nil {
+			logger.Errorf("registering module: %v", err)
+		}
+	}
+}
+
+// Modules returns the registry which is served at /settings, own modules are added with Register
+func (g *Goomo) Modules() *ModuleRegistry {
+	return g.modules
+}
+
+const postitTrackerMuxId = "pt"
+
+// postitAI feeds the camera images into the PostitTracker and its features into the MovementAI
+type postitAI struct {
+	g *Goomo
+}
+
+func (m *postitAI) ID() string             { return PostitAIModule }
+func (m *postitAI) Dependencies() []string { return nil }
+func (m *postitAI) Conflicts() []string    { return nil }
+
+func (m *postitAI) Status() ModuleStatus {
+	g := m.g
+	active := g.ai != nil && g.pt != nil &&
+		g.ai.InboundPostits != nil && g.pt.Inbound != nil && g.pt.Outbound != nil &&
+		g.matMux.Has(postitTrackerMuxId)
+	return ModuleStatus{Active: active}
+}
+
+func (m *postitAI) Activate() error {
+	g := m.g
+	mats := make(chan *ManagedMat)
+	features := make(chan [][]Feature)
+
+	// init postit tracker
+	if g.pt == nil {
+		g.pt = &PostitTracker{}
+		g.pt.Descriptions = g.config.Postits
+		g.pt.watchdog = g.watchdog
+		g.pt.metrics = g.metrics
+	}
+	g.pt.Inbound = mats
+	g.pt.Outbound = features
+
+	// init postit ai
+	if g.ai == nil {
+		g.ai = g.newMovementAI()
+	}
+	g.ai.InboundPostits = features
+
+	// add to matmux
+	g.matMux.Add(postitTrackerMuxId, mats)
+
+	// start go routines
+	go g.pt.StartPostitTracker()
+	go g.ai.StartPostitAI(g.pt.Outbound)
+	return nil
+}
+
+func (m *postitAI) Deactivate() error {
+	g := m.g
+	// remove from matmux
+	g.matMux.Remove(postitTrackerMuxId)
+
+	// deactivate postit tracker
+	if g.pt != nil {
+		if g.pt.Inbound != nil {
+			close(g.pt.Inbound)
+		}
+		g.pt.Inbound = nil
+		g.pt.Outbound = nil
+	}
+
+	// deactivate postit ai
+	if g.ai != nil {
+		if g.ai.InboundPostits != nil {
+			close(g.ai.InboundPostits)
+		}
+		g.ai.InboundPostits = nil
+	}
+	return nil
+}
+
+const trafficSignTrackerMuxId = "ts"
+
+// trafficSignAI feeds the camera images into the TrafficSignTracker and its signs into the MovementAI
+type trafficSignAI struct {
+	g *Goomo
+}
+
+func (m *trafficSignAI) ID() string             { return TrafficSignAIModule }
+func (m *trafficSignAI) Dependencies() []string { return nil }
+func (m *trafficSignAI) Conflicts() []string    { return nil }
+
+func (m *trafficSignAI) Status() ModuleStatus {
+	g := m.g
+	active := g.tT != nil && g.ai != nil &&
+		g.tT.Inbound != nil && g.tT.Outbound != nil && g.ai.InboundTrafficSigns != nil &&
+		g.matMux.Has(trafficSignTrackerMuxId)
+	return ModuleStatus{Active: active}
+}
+
+func (m *trafficSignAI) Activate() error {
+	g := m.g
+	mats := make(chan *ManagedMat)
+	trafficsigns := make(chan *TrafficSignFeature)
+
+	// init traffic sign tracker
+	if g.tT == nil {
+		g.tT = &TrafficSignTracker{
+			Descriptions: g.config.TrafficSigns,
+			ModelDir:     g.config.TrafficSignNN,
+			Distances:    g.distanceLookup(),
+			metrics:      g.metrics,
+		}
+	}
+	g.tT.Inbound = mats
+	g.tT.Outbound = trafficsigns
+
+	// init traffic sign ai
+	if g.ai == nil {
+		g.ai = g.newMovementAI()
+	}
+	g.ai.InboundTrafficSigns = trafficsigns
+
+	// add to matmux
+	g.matMux.Add(trafficSignTrackerMuxId, g.tT.Inbound)
+
+	// start go routines
+	go g.tT.StartTrafficSignTracker()
+	go g.ai.StartTrafficSignAI(g.tT.Outbound)
+	return nil
+}
+
+func (m *trafficSignAI) Deactivate() error {
+	g := m.g
+	// remove from matmux
+	g.matMux.Remove(trafficSignTrackerMuxId)
+
+	// deactivate traffic sign tacker
+	if g.tT != nil {
+		if g.tT.Inbound != nil {
+			close(g.tT.Inbound)
+		}
+		g.tT.Inbound = nil
+		g.tT.Outbound = nil
+	}
+
+	// deactivate traffic sign ai
+	if g.ai != nil {
+		if g.ai.InboundTrafficSigns != nil {
+			close(g.ai.InboundTrafficSigns)
+		}
+		g.ai.InboundTrafficSigns = nil
+	}
+	return nil
+}
+
+const slamMuxId = "slam"
+
+// slamModule feeds the camera images into a new MonoSLAM on every activation
+type slamModule struct {
+	g *Goomo
+}
+
+func (m *slamModule) ID() string             { return SlamModule }
+func (m *slamModule) Dependencies() []string { return nil }
+func (m *slamModule) Conflicts() []string    { return nil }
+
+func (m *slamModule) Status() ModuleStatus {
+	g := m.g
+	active := g.slam != nil && g.slam.Inbound != nil && g.matMux.Has(slamMuxId)
+	return ModuleStatus{Active: active}
+}
+
+func (m *slamModule) Activate() error {
+	g := m.g
+	chanMat := make(chan *ManagedMat)
+
+	// init slam
+	if g.slam == nil {
+		g.slam = NewMonoSLAM(
+			g.config.Slam.Vocabulary,
+			g.config.Slam.Settings,
+			g.config.Slam.Viewer,
+			g.config.Slam.SemiDense)
+		g.slam.telemetry = g.telemetry
+		g.slam.metrics = g.metrics
+	}
+	// set before StartSlam runs, so the status is active right away
+	g.slam.Inbound = chanMat
+
+	// add to matmux
+	g.matMux.Add(slamMuxId, chanMat)
+
+	// start go routines, StartSlam shuts the MonoSLAM down after Deactivate
+	slam := g.slam
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		slam.StartSlam(chanMat)
+	}()
+	return nil
+}
+
+func (m *slamModule) Deactivate() error {
+	g := m.g
+	// remove from matmux
+	g.matMux.Remove(slamMuxId)
+
+	// deactivate slam
+	if g.slam != nil {
+		if g.slam.Inbound != nil {
+			close(g.slam.Inbound)
+		}
+		g.slam.Inbound = nil
+	}
+
+	g.slam = nil
+	return nil
+}
+
+const videoCaptureName = "video-capture"
+
+// videoCapture records the camera images into the RecordingLibrary
+type videoCapture struct {
+	g *Goomo
+}
+
+func (m *videoCapture) ID() string             { return VideoCaptureModule }
+func (m *videoCapture) Dependencies() []string { return nil }
+func (m *videoCapture) Conflicts() []string    { return nil }
+
+func (m *videoCapture) Status() ModuleStatus {
+	if !m.g.isVideoCaptureRunning() {
+		return ModuleStatus{}
+	}
+	status := ModuleStatus{Active: true}
+	if l, err := m.g.Recordings(); err == nil {
+		if r, err := l.Latest(); err == nil && r.Active {
+			status.Message = "recording " + r.ID
+		}
+	}
+	return status
+}
+
+func (m *videoCapture) Activate() error {
+	_, err := m.g.StartRecording(videoCaptureName, nil)
+	return err
+}
+
+func (m *videoCapture) Deactivate() error {
+	_, err := m.g.StopRecording()
+	return err
+}
@@ -1,29 +1,43 @@
 package goomo
 
 import (
+	"context"
 	"sync"
 )
 
+// Multiplexer passes every inbound frame to all of its receivers until ctx is done or the inbound channel is closed
 type Multiplexer interface {
-	Multiplex()
+	Multiplex(ctx context.Context)
 }
 
 type MatMultiplexer struct {
 	Inbound       chan *ManagedMat
 	outboundMutex *sync.Mutex
 	outbounds     map[string]chan *ManagedMat
+	metrics       *moduleMetrics
 }
 
-func (m *MatMultiplexer) Multiplex() {
+func (m *MatMultiplexer) Multiplex(ctx context.Context) {
 	logger.Debug("MatMultiplexer started.")
-	for managed := range m.Inbound {
+	metrics := m.metrics.get()
+	for {
+		var managed *ManagedMat
+		var ok bool
+		select {
+		case managed, ok = <-m.Inbound:
+		case <-ctx.Done():
+		}
+		if !ok {
+			break
+		}
 		m.outboundMutex.Lock()
-		for _, outbound := range m.outbounds {
+		for id, outbound := range m.outbounds {
 			managed.Assign()
 			select {
 			case outbound <- managed:
+				metrics.muxFrames.With("mat", id).Inc()
 			default:
-				// nothing happens
+				metrics.muxSkipped.With("mat", id).Inc()
 				managed.Done()
 			}
 		}
@@ -57,17 +71,29 @@ type JPGMultiplexer struct {
 	Inbound       chan JPG
 	outboundMutex *sync.Mutex
 	outbounds     map[string]chan JPG
+	metrics       *moduleMetrics
 }
 
-func (j *JPGMultiplexer) Multiplex() {
+func (j *JPGMultiplexer) Multiplex(ctx context.Context) {
 	logger.Debug("JPGMultiplexer started.")
-	for jpg := range j.Inbound {
+	metrics := j.metrics.get()
+	for {
+		var jpg JPG
+		var ok bool
+		select {
+		case jpg, ok = <-j.Inbound:
+		case <-ctx.Done():
+		}
+		if !ok {
+			break
+		}
 		j.outboundMutex.Lock()
-		for _, outbound := range j.outbounds {
+		for id, outbound := range j.outbounds {
 			select {
 			case outbound <- jpg:
+				metrics.muxFrames.With("jpg", id).Inc()
 			default:
-				// nothing happens
+				metrics.muxSkipped.With("jpg", id).Inc()
 			}
 		}
 		j.outboundMutex.Unlock()
@@ -0,0 +1,299 @@
+package goomo
+
+//goomo_recordings.go keeps the captured videos with their names and metadata in a directory with a quota
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	recordingIndexFile      = "index.json"
+	recordingExt            = ".h264"
+	recordingQuotaInterval  = time.Second
+	defaultRecordingsDir    = "video"
+	defaultRecordingQuotaMB = 2048
+)
+
+var (
+	ErrRecordingActive   = errors.New("a recording is already running")
+	ErrNoRecording       = errors.New("no recording is running")
+	ErrRecordingNotFound = errors.New("recording not found")
+	ErrQuotaExceeded     = errors.New("recording quota exceeded")
+)
+
+// Recording describes one captured video, its ID never changes
+type Recording struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Started  time.Time         `json:"started"`
+	Stopped  *time.Time        `json:"stopped,omitempty"`
+	Active   bool              `json:"active"`
+	Size     int64             `json:"size"`
+}
+
+// RecordingList is the response of /recordings, the sizes are in bytes
+type RecordingList struct {
+	Recordings []Recording `json:"recordings"`
+	Used       int64       `json:"used"`
+	Quota      int64       `json:"quota"`
+}
+
+// RecordingLibrary stores the videos as <id>.h264 and their descriptions in index.json of Dir.
+// Only one recording runs at a time, OnQuotaExceeded is called when it grows beyond Quota.
+type RecordingLibrary struct {
+	Dir   string
+	Quota int64
+	// OnQuotaExceeded has to stop the running recording
+	OnQuotaExceeded func(r Recording)
+
+	lock       sync.Mutex
+	recordings map[string]*Recording
+	active     string
+	watchStop  chan bool
+}
+
+// NewRecordingLibrary creates dir if necessary and loads its index
+func NewRecordingLibrary(dir string, quota int64) (*RecordingLibrary, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("creating recordings directory: %v", err)
+	}
+	l := &RecordingLibrary{
+		Dir:        dir,
+		Quota:      quota,
+		recordings: make(map[string]*Recording),
+	}
+	err = l.load()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *RecordingLibrary) load() error {
+	b, err := ioutil.ReadFile(filepath.Join(l.Dir, recordingIndexFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading recordings index: %v", err)
+	}
+	var recordings []*Recording
+	err = json.Unmarshal(b, &recordings)
+	if err != nil {
+		return fmt.Errorf("decoding recordings index: %v", err)
+	}
+	for _, r := range recordings {
+		// recordings which were running when the process ended stop with their last write
+		if r.Active {
+			r.Active = false
+			stopped := r.Started
+			if info, err := os.Stat(l.Path(r.ID)); err == nil {
+				stopped = info.ModTime()
+			}
+			r.Stopped = &stopped
+		}
+		l.recordings[r.ID] = r
+	}
+	return nil
+}
+
+// save has to be called with lock held, the index is replaced atomically
+func (l *RecordingLibrary) save() error {
+	recordings := make([]*Recording, 0, len(l.recordings))
+	for _, r := range l.recordings {
+		recordings = append(recordings, r)
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].Started.Before(recordings[j].Started) })
+	b, err := json.MarshalIndent(recordings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding recordings index: %v", err)
+	}
+	tmp := filepath.Join(l.Dir, recordingIndexFile+".tmp")
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return fmt.Errorf("writing recordings index: %v", err)
+	}
+	err = os.Rename(tmp, filepath.Join(l.Dir, recordingIndexFile))
+	if err != nil {
+		return fmt.Errorf("writing recordings index: %v", err)
+	}
+	return nil
+}
+
+// Path returns the video file of the recording
+func (l *RecordingLibrary) Path(id string) string {
+	return filepath.Join(l.Dir, id+recordingExt)
+}
+
+func newRecordingID(now time.Time) (string, error) {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("creating recording id: %v", err)
+	}
+	return now.UTC().Format("20060102-150405-") + hex.EncodeToString(b), nil
+}
+
+// withSize has to be called with lock held, it returns a copy with the current file size
+func (l *RecordingLibrary) withSize(r *Recording) Recording {
+	c := *r
+	if info, err := os.Stat(l.Path(r.ID)); err == nil {
+		c.Size = info.Size()
+	}
+	return c
+}
+
+// used has to be called with lock held
+func (l *RecordingLibrary) used() int64 {
+	var used int64
+	for _, r := range l.recordings {
+		used += l.withSize(r).Size
+	}
+	return used
+}
+
+// Start adds a recording whose video has to be written to Path(id)
+func (l *RecordingLibrary) Start(name string, metadata map[string]string) (Recording, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.active != "" {
+		return Recording{}, ErrRecordingActive
+	}
+	if l.used() >= l.Quota {
+		return Recording{}, ErrQuotaExceeded
+	}
+	now := time.Now()
+	id, err := newRecordingID(now)
+	if err != nil {
+		return Recording{}, err
+	}
+	if name == "" {
+		name = id
+	}
+	r := &Recording{
+		ID:       id,
+		Name:     name,
+		Metadata: metadata,
+		Started:  now,
+		Active:   true,
+	}
+	l.recordings[id] = r
+	l.active = id
+	err = l.save()
+	if err != nil {
+		delete(l.recordings, id)
+		l.active = ""
+		return Recording{}, err
+	}
+	l.watchStop = make(chan bool)
+	go l.watchQuota(l.watchStop)
+	return *r, nil
+}
+
+// Stop marks the running recording as stopped, its video has to be closed by the caller
+func (l *RecordingLibrary) Stop() (Recording, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	r, ok := l.recordings[l.active]
+	if !ok {
+		return Recording{}, ErrNoRecording
+	}
+	now := time.Now()
+	r.Stopped = &now
+	r.Active = false
+	l.active = ""
+	close(l.watchStop)
+	return l.withSize(r), l.save()
+}
+
+func (l *RecordingLibrary) watchQuota(stop chan bool) {
+	ticker := time.NewTicker(recordingQuotaInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		l.lock.Lock()
+		exceeded := l.used() > l.Quota
+		r, ok := l.recordings[l.active]
+		var active Recording
+		if ok {
+			active = l.withSize(r)
+		}
+		onExceeded := l.OnQuotaExceeded
+		l.lock.Unlock()
+		if exceeded && ok && onExceeded != nil {
+			logger.Warnf("recording %s exceeded the quota of %d bytes", active.ID, l.Quota)
+			onExceeded(active)
+			return
+		}
+	}
+}
+
+// List returns all recordings, the oldest first
+func (l *RecordingLibrary) List() RecordingList {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	list := RecordingList{
+		Recordings: make([]Recording, 0, len(l.recordings)),
+		Quota:      l.Quota,
+	}
+	for _, r := range l.recordings {
+		c := l.withSize(r)
+		list.Recordings = append(list.Recordings, c)
+		list.Used += c.Size
+	}
+	sort.Slice(list.Recordings, func(i, j int) bool { return list.Recordings[i].Started.Before(list.Recordings[j].Started) })
+	return list
+}
+
+func (l *RecordingLibrary) Get(id string) (Recording, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	r, ok := l.recordings[id]
+	if !ok {
+		return Recording{}, ErrRecordingNotFound
+	}
+	return l.withSize(r), nil
+}
+
+// Latest returns the recording which was started last
+func (l *RecordingLibrary) Latest() (Recording, error) {
+	list := l.List()
+	if len(list.Recordings) == 0 {
+		return Recording{}, ErrRecordingNotFound
+	}
+	return list.Recordings[len(list.Recordings)-1], nil
+}
+
+// Delete removes a stopped recording and its video
+func (l *RecordingLibrary) Delete(id string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.recordings[id]; !ok {
+		return ErrRecordingNotFound
+	}
+	if id == l.active {
+		return ErrRecordingActive
+	}
+	err := os.Remove(l.Path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting recording %s: %v", id, err)
+	}
+	delete(l.recordings, id)
+	return l.save()
+}
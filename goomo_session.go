@@ -0,0 +1,396 @@
+package goomo
+
+//goomo_session.go records the raw frames of all streams and the commands sent to the Loomo, and replays them into the modules
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A session file starts with sessionMagic followed by records of a kind byte and the offset since the start in ns.
+// Frames continue with the stream name, the Loomo timestamp and the length prefixed payload,
+// commands with their message as written to the Loomo, which carries its own length.
+const (
+	sessionMagic     = "GOOMO-SESSION-1\n"
+	sessionFrame     = 'D'
+	sessionCommand   = 'C'
+	sessionBuffer    = 1024
+	maxSessionFrame  = 64 << 20
+	sessionListenId  = "session"
+	maxSessionStream = 255
+)
+
+// SessionEvent is a frame or a command of a session, Offset is the time since the recording started
+type SessionEvent struct {
+	Offset  time.Duration
+	Stream  string
+	Data    *LoomoData
+	Command Command
+}
+
+func (e SessionEvent) IsCommand() bool {
+	return e.Command != nil
+}
+
+// SessionStats counts the records of a session
+type SessionStats struct {
+	Frames   int `json:"frames"`
+	Commands int `json:"commands"`
+	// DroppedCommands were not recorded because the disk could not keep up
+	DroppedCommands int `json:"droppedCommands"`
+}
+
+// SessionRecorder writes every frame and every command which was written to the Loomo into one file,
+// ordered by their arrival. Frames and commands are dropped if the disk cannot keep up.
+type SessionRecorder struct {
+	lc    *LoomoCommunicator
+	id    string
+	f     *os.File
+	w     *bufio.Writer
+	start time.Time
+	data  chan StreamData
+	cmds  chan IntakeCommand
+	stop  chan bool
+	done  chan error
+	lock  sync.Mutex
+	stats SessionStats
+	// Close may be called again on shutdown
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// RecordSession starts recording the streams and commands of lc to path until Close is called
+func RecordSession(lc *LoomoCommunicator, path string) (*SessionRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating session file: %v", err)
+	}
+	r := &SessionRecorder{
+		lc:    lc,
+		id:    sessionListenId + "-" + path,
+		f:     f,
+		w:     bufio.NewWriter(f),
+		start: time.Now(),
+		data:  make(chan StreamData, sessionBuffer),
+		cmds:  make(chan IntakeCommand, sessionBuffer),
+		stop:  make(chan bool),
+		done:  make(chan error, 1),
+	}
+	_, err = r.w.WriteString(sessionMagic)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("writing session header: %v", err)
+	}
+	lc.AddDataListener(r.id, r.data)
+	lc.AddIntakeListener(r.id, r.intake)
+	go r.record()
+	logger.Infof("Recording session to %s", path)
+	return r, nil
+}
+
+func (r *SessionRecorder) intake(cmd IntakeCommand) {
+	select {
+	case r.cmds <- cmd:
+	default:
+		r.lock.Lock()
+		r.stats.DroppedCommands++
+		r.lock.Unlock()
+	}
+}
+
+// record writes the buffered frames and commands until both buffers are empty and the recorder is stopped
+func (r *SessionRecorder) record() {
+	var frame *StreamData
+	var cmd *IntakeCommand
+	var err error
+	for err == nil {
+		if frame == nil {
+			select {
+			case d := <-r.data:
+				frame = &d
+			default:
+			}
+		}
+		if cmd == nil {
+			select {
+			case c := <-r.cmds:
+				cmd = &c
+			default:
+			}
+		}
+		// both buffers are in arrival order, so the earlier of their heads is written first
+		switch {
+		case frame != nil && (cmd == nil || !cmd.Time.Before(frame.Time)):
+			err = r.writeFrame(*frame)
+			frame = nil
+		case cmd != nil:
+			err = r.writeCommand(*cmd)
+			cmd = nil
+		default:
+			select {
+			case d := <-r.data:
+				frame = &d
+			case c := <-r.cmds:
+				cmd = &c
+			case <-r.stop:
+				r.done <- nil
+				return
+			}
+		}
+	}
+	logger.Errorf("recording session: %v", err)
+	r.lc.RemoveDataListener(r.id)
+	r.lc.RemoveIntakeListener(r.id)
+	<-r.stop
+	r.done <- err
+}
+
+func (r *SessionRecorder) writeHeader(kind byte, arrived time.Time) {
+	r.w.WriteByte(kind)
+	binary.Write(r.w, binary.BigEndian, int64(arrived.Sub(r.start)))
+}
+
+func (r *SessionRecorder) writeFrame(d StreamData) error {
+	if len(d.Stream) > maxSessionStream {
+		return fmt.Errorf("stream name %q is too long", d.Stream)
+	}
+	r.writeHeader(sessionFrame, d.Time)
+	r.w.WriteByte(byte(len(d.Stream)))
+	r.w.WriteString(d.Stream)
+	binary.Write(r.w, binary.BigEndian, d.Data.timestamp)
+	binary.Write(r.w, binary.BigEndian, uint32(len(d.Data.data)))
+	_, err := r.w.Write(d.Data.data)
+	if err != nil {
+		return fmt.Errorf("writing %s frame: %v", d.Stream, err)
+	}
+	r.lock.Lock()
+	r.stats.Frames++
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *SessionRecorder) writeCommand(c IntakeCommand) error {
+	msg, err := c.Command.MsgFormat()
+	if err != nil {
+		return fmt.Errorf("encoding %s: %v", c.Command.Tag(), err)
+	}
+	r.writeHeader(sessionCommand, c.Time)
+	_, err = r.w.Write(msg)
+	if err != nil {
+		return fmt.Errorf("writing %s: %v", c.Command.Tag(), err)
+	}
+	r.lock.Lock()
+	r.stats.Commands++
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *SessionRecorder) Stats() SessionStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.stats
+}
+
+// Close stops the recording and writes the remaining records to the file, later calls return the same error
+func (r *SessionRecorder) Close() error {
+	r.closeOnce.Do(func() {
+		r.closeErr = r.close()
+	})
+	return r.closeErr
+}
+
+func (r *SessionRecorder) close() error {
+	r.lc.RemoveDataListener(r.id)
+	r.lc.RemoveIntakeListener(r.id)
+	close(r.stop)
+	// record drains the records which were received before the listeners were removed
+	err := <-r.done
+	if flushErr := r.w.Flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("writing session: %v", flushErr)
+	}
+	if closeErr := r.f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("closing session: %v", closeErr)
+	}
+	stats := r.Stats()
+	logger.Infof("Recorded %d frames and %d commands, dropped %d commands", stats.Frames, stats.Commands,
+		stats.DroppedCommands)
+	return err
+}
+
+// SessionReader reads the events of a session file in the order they were recorded
+type SessionReader struct {
+	r *bufio.Reader
+}
+
+func NewSessionReader(r io.Reader) (*SessionReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(sessionMagic))
+	_, err := io.ReadFull(br, magic)
+	if err != nil || !bytes.Equal(magic, []byte(sessionMagic)) {
+		return nil, fmt.Errorf("not a session file")
+	}
+	return &SessionReader{r: br}, nil
+}
+
+// Next returns the next event or io.EOF at the end of the session
+func (s *SessionReader) Next() (SessionEvent, error) {
+	var e SessionEvent
+	kind, err := s.r.ReadByte()
+	if err != nil {
+		return e, err
+	}
+	var offset int64
+	err = binary.Read(s.r, binary.BigEndian, &offset)
+	if err != nil {
+		return e, fmt.Errorf("reading offset: %v", unexpectedEOF(err))
+	}
+	e.Offset = time.Duration(offset)
+
+	switch kind {
+	case sessionFrame:
+		n, err := s.r.ReadByte()
+		if err != nil {
+			return e, fmt.Errorf("reading stream: %v", unexpectedEOF(err))
+		}
+		stream := make([]byte, n)
+		_, err = io.ReadFull(s.r, stream)
+		if err != nil {
+			return e, fmt.Errorf("reading stream: %v", unexpectedEOF(err))
+		}
+		e.Stream = string(stream)
+		var header struct {
+			Timestamp uint64
+			Length    uint32
+		}
+		err = binary.Read(s.r, binary.BigEndian, &header)
+		if err != nil {
+			return e, fmt.Errorf("reading %s frame: %v", e.Stream, unexpectedEOF(err))
+		}
+		if header.Length > maxSessionFrame {
+			return e, fmt.Errorf("%s frame of %d bytes is too large", e.Stream, header.Length)
+		}
+		data := make([]byte, header.Length)
+		_, err = io.ReadFull(s.r, data)
+		if err != nil {
+			return e, fmt.Errorf("reading %s frame: %v", e.Stream, unexpectedEOF(err))
+		}
+		e.Data = &LoomoData{timestamp: header.Timestamp, data: data}
+	case sessionCommand:
+		e.Command, err = NewCommandReader(s.r).ReadCommand()
+		if err != nil {
+			return e, fmt.Errorf("reading command: %v", unexpectedEOF(err))
+		}
+	default:
+		return e, fmt.Errorf("unknown record %q", kind)
+	}
+	return e, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// SessionReplayer feeds the frames of a session into the handlers of their streams, e.g. the DataProcessor.
+// Speed 1 keeps the recorded timing, 2 replays twice as fast and 0 as fast as the handlers receive.
+type SessionReplayer struct {
+	Path  string
+	Speed float64
+	// Recorded receives the recorded commands at their time for comparison, it is not used if nil
+	Recorded chan SessionEvent
+
+	handlers map[string]StreamDataHandler
+}
+
+func NewSessionReplayer(path string, speed float64) *SessionReplayer {
+	return &SessionReplayer{
+		Path:     path,
+		Speed:    speed,
+		handlers: make(map[string]StreamDataHandler),
+	}
+}
+
+// RegisterHandler replays the frames of the handler's stream into it, frames of other streams are skipped
+func (r *SessionReplayer) RegisterHandler(handler SensorHandler) {
+	r.handlers[handler.Stream()] = handler
+}
+
+// Replay blocks until the session was replayed or ctx is done, cmds is passed to the handlers.
+// The handlers are stopped by closing their streams and Recorded is closed at the end.
+func (r *SessionReplayer) Replay(ctx context.Context, cmds chan Command) error {
+	if r.Recorded != nil {
+		defer close(r.Recorded)
+	}
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return fmt.Errorf("opening session: %v", err)
+	}
+	defer f.Close()
+	reader, err := NewSessionReader(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", r.Path, err)
+	}
+
+	streams := make(map[string]*SensorStream, len(r.handlers))
+	for name, handler := range r.handlers {
+		stream := NewSensorStream()
+		streams[name] = stream
+		go handler.HandleStream(stream, cmds)
+	}
+	defer func() {
+		for _, stream := range streams {
+			close(stream.Data)
+		}
+	}()
+
+	start := time.Now()
+	var stats SessionStats
+	for {
+		e, err := reader.Next()
+		if err == io.EOF {
+			logger.Infof("Replayed %d frames and %d commands of %s", stats.Frames, stats.Commands, r.Path)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", r.Path, err)
+		}
+		if r.Speed > 0 {
+			due := start.Add(time.Duration(float64(e.Offset) / r.Speed))
+			timer := time.NewTimer(time.Until(due))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if e.IsCommand() {
+			stats.Commands++
+			if r.Recorded != nil {
+				select {
+				case r.Recorded <- e:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			continue
+		}
+		if stream, ok := streams[e.Stream]; ok {
+			stats.Frames++
+			select {
+			case stream.Data <- e.Data:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package goomo
+
+//goomo_shaper.go ramps the velocities of the AIs and of teleop, so new values do not jump straight to the wheels
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLinearAcceleration  = 0.5
+	defaultAngularAcceleration = 1.5
+	defaultLinearJerk          = 2
+	defaultAngularJerk         = 6
+	defaultControlRate         = 20
+	maxControlRate             = 100
+)
+
+// shapedAxis ramps one velocity towards its target with limited acceleration and jerk
+type shapedAxis struct {
+	name   string
+	max    float64
+	accel  float64
+	jerk   float64
+	target float64
+	v      float64
+	a      float64
+	sent   float32
+}
+
+// clamp limits v to [-max, max], NaN and infinite values stop the axis
+func (x *shapedAxis) clamp(v float32, metrics *moduleMetrics) float32 {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f) || math.IsInf(f, 0):
+		f = 0
+	case math.Abs(f) > x.max:
+		f = math.Copysign(x.max, f)
+	default:
+		return v
+	}
+	metrics.get().motionClamped.With(x.name).Inc()
+	return float32(f)
+}
+
+// step advances the velocity by dt. The acceleration aims at the most which can still be reduced to 0
+// until the target is reached, and changes by at most the jerk.
+func (x *shapedAxis) step(dt float64) {
+	diff := x.target - x.v
+	if diff == 0 && x.a == 0 {
+		return
+	}
+	want := math.Min(x.accel, math.Sqrt(2*x.jerk*math.Abs(diff)))
+	want = math.Copysign(math.Min(want, math.Abs(diff)/dt), diff)
+	change := x.jerk * dt
+	x.a += math.Max(-change, math.Min(change, want-x.a))
+	next := x.v + x.a*dt
+	if (x.target-next)*diff <= 0 {
+		// reached or passed the target
+		x.v, x.a = x.target, 0
+		return
+	}
+	x.v = next
+}
+
+func (x *shapedAxis) halt() {
+	x.target, x.v, x.a, x.sent = 0, 0, 0, 0
+}
+
+// MotionShaper sits between the drivers and the Cmds of the LoomoCommunicator. Drivers set target velocities,
+// which are clamped to the limits, and the shaper sends ramped velocities at its control rate until they are reached.
+// Stop bypasses the ramp like LoomoCommunicator.Stop.
+type MotionShaper struct {
+	lc      *LoomoCommunicator
+	rate    float64
+	lock    sync.Mutex
+	linear  shapedAxis
+	angular shapedAxis
+	// held drops the velocities of SetAutonomous, resend sends the velocities again after a Stop
+	held   bool
+	resend bool
+	// online reports whether the velocities can be sent, see replay
+	online func() bool
+}
+
+// NewMotionShaper limits the velocities to maxLv in m/s and maxAv in rad/s and ramps them as configured
+func NewMotionShaper(lc *LoomoCommunicator, maxLv, maxAv float32, config MotionConfig) *MotionShaper {
+	s := &MotionShaper{
+		lc:      lc,
+		linear:  shapedAxis{name: "linear"},
+		angular: shapedAxis{name: "angular"},
+		online:  lc.IsConnected,
+	}
+	s.configure(maxLv, maxAv, config)
+	return s
+}
+
+// configure has to be called before Start
+func (s *MotionShaper) configure(maxLv, maxAv float32, config MotionConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rate = config.ControlRate
+	s.linear.max = float64(maxLv)
+	s.linear.accel = float64(config.LinearAcceleration)
+	s.linear.jerk = float64(config.LinearJerk)
+	s.angular.max = float64(maxAv)
+	s.angular.accel = float64(config.AngularAcceleration)
+	s.angular.jerk = float64(config.AngularJerk)
+}
+
+// Start sends the ramped velocities to the Loomo until ctx is done. While it is not connected the ramps pause,
+// so no commands are queued which could only fail.
+func (s *MotionShaper) Start(ctx context.Context) {
+	interval := time.Duration(float64(time.Second) / s.rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	connected := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.isOnline() {
+			if connected {
+				s.pause()
+				connected = false
+			}
+			continue
+		}
+		connected = true
+		for _, cmd := range s.step(interval.Seconds()) {
+			select {
+			case s.lc.Cmds <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// step advances both axes and returns the commands for the velocities which changed
+func (s *MotionShaper) step(dt float64) []Command {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.linear.step(dt)
+	s.angular.step(dt)
+	var cmds []Command
+	if lv := float32(s.linear.v); lv != s.linear.sent || s.resend {
+		cmds = append(cmds, &CLVLCommand{Lv: lv})
+		s.linear.sent = lv
+	}
+	if av := float32(s.angular.v); av != s.angular.sent || s.resend {
+		cmds = append(cmds, &CAVLCommand{Av: av})
+		s.angular.sent = av
+	}
+	s.resend = false
+	return cmds
+}
+
+// pause is called when the connection is lost. The targets are dropped, so the Loomo does not drive
+// after reconnecting until a driver sets new ones, and zero velocities are sent once it is connected again.
+func (s *MotionShaper) pause() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.halt()
+}
+
+// replay sends the velocities without a connection, as a replayed session receives them from Cmds
+func (s *MotionShaper) replay() {
+	s.lock.Lock()
+	s.online = func() bool { return true }
+	s.lock.Unlock()
+}
+
+func (s *MotionShaper) isOnline() bool {
+	s.lock.Lock()
+	online := s.online
+	s.lock.Unlock()
+	return online()
+}
+
+// connected rejects the velocities of clients while the Loomo cannot receive them
+func (s *MotionShaper) connected(tag CommandTag) error {
+	if !s.isOnline() {
+		return &CommandError{tag, OpWrite, ErrNotConnected}
+	}
+	return nil
+}
+
+// Set ramps towards both velocities and returns them clamped to the limits, e.g. for teleop
+func (s *MotionShaper) Set(lv, av float32) (float32, float32, error) {
+	if err := s.connected(CLVL); err != nil {
+		return 0, 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lv, av = s.linear.clamp(lv, s.lc.metrics), s.angular.clamp(av, s.lc.metrics)
+	s.linear.target, s.angular.target = float64(lv), float64(av)
+	return lv, av, nil
+}
+
+// SetLinear ramps towards lv and leaves the angular velocity unchanged
+func (s *MotionShaper) SetLinear(lv float32) (float32, error) {
+	if err := s.connected(CLVL); err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lv = s.linear.clamp(lv, s.lc.metrics)
+	s.linear.target = float64(lv)
+	return lv, nil
+}
+
+// SetAngular ramps towards av and leaves the linear velocity unchanged
+func (s *MotionShaper) SetAngular(av float32) (float32, error) {
+	if err := s.connected(CAVL); err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	av = s.angular.clamp(av, s.lc.metrics)
+	s.angular.target = float64(av)
+	return av, nil
+}
+
+// SetAutonomous is Set for the AIs, which are not told about the connection.
+// While the shaper is held the velocities are dropped and false is returned.
+func (s *MotionShaper) SetAutonomous(lv, av float32) (float32, float32, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.held {
+		return 0, 0, false
+	}
+	lv, av = s.linear.clamp(lv, s.lc.metrics), s.angular.clamp(av, s.lc.metrics)
+	s.linear.target, s.angular.target = float64(lv), float64(av)
+	return lv, av, true
+}
+
+// Current returns the velocities which were last sent
+func (s *MotionShaper) Current() (float32, float32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.linear.sent, s.angular.sent
+}
+
+// Stop sets both velocities to 0 ahead of all queued commands without ramping and drops the targets
+func (s *MotionShaper) Stop() error {
+	s.lock.Lock()
+	s.halt()
+	s.lock.Unlock()
+	return s.lc.Stop()
+}
+
+// Hold stops like Stop and drops the velocities of SetAutonomous until Release, e.g. on a watchdog fault
+func (s *MotionShaper) Hold() error {
+	s.lock.Lock()
+	s.held = true
+	s.halt()
+	s.lock.Unlock()
+	return s.lc.Stop()
+}
+
+func (s *MotionShaper) Release() {
+	s.lock.Lock()
+	s.held = false
+	s.lock.Unlock()
+}
+
+// halt has to be called with lock held. A velocity which the control loop is sending meanwhile
+// could arrive after the stop, so zero velocities are sent again on the next step.
+func (s *MotionShaper) halt() {
+	s.linear.halt()
+	s.angular.halt()
+	s.resend = true
+}
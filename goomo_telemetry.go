@@ -0,0 +1,248 @@
+package goomo
+
+//goomo_telemetry.go publishes what the modules detect and decide to the clients of /ws/telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Topics of the TelemetryHub
+const (
+	TopicPostits      = "postits"
+	TopicTrafficSigns = "trafficsigns"
+	TopicAIState      = "ai-state"
+	TopicVelocities   = "velocities"
+	TopicSlam         = "slam"
+	TopicConnection   = "connection"
+	TopicWatchdog     = "watchdog"
+)
+
+var telemetryTopics = []string{TopicPostits, TopicTrafficSigns, TopicAIState, TopicVelocities, TopicSlam, TopicConnection, TopicWatchdog}
+
+const (
+	telemetryBuffer       = 64
+	telemetryWriteTimeout = 5 * time.Second
+	telemetryPingInterval = 30 * time.Second
+)
+
+// TelemetryMessage is sent to every client which subscribed to its topic
+type TelemetryMessage struct {
+	Topic string      `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type AIStateTelemetry struct {
+	State string `json:"state"`
+}
+
+// VelocityTelemetry contains the velocities which were last sent to the Loomo
+type VelocityTelemetry struct {
+	Lv float32 `json:"lv"`
+	Av float32 `json:"av"`
+}
+
+type SlamTelemetry struct {
+	State TrackingState `json:"state"`
+	X     float32       `json:"x"`
+	Y     float32       `json:"y"`
+	Z     float32       `json:"z"`
+}
+
+// TelemetrySubscription is sent by clients to change their topics, e.g. {"subscribe": ["postits"]}
+type TelemetrySubscription struct {
+	Subscribe   []string `json:"subscribe,omitempty"`
+	Unsubscribe []string `json:"unsubscribe,omitempty"`
+}
+
+type telemetryClient struct {
+	lock   sync.Mutex
+	topics map[string]bool
+	send   chan []byte
+}
+
+func (c *telemetryClient) subscribed(topic string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.topics[topic]
+}
+
+func (c *telemetryClient) change(s TelemetrySubscription) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, topic := range s.Subscribe {
+		c.topics[topic] = true
+	}
+	for _, topic := range s.Unsubscribe {
+		delete(c.topics, topic)
+	}
+}
+
+// TelemetryHub sends the published messages to all subscribed clients, slow clients miss messages.
+// The last message of every topic is sent to new subscribers immediately.
+// A nil TelemetryHub discards everything, so modules can publish without checking.
+type TelemetryHub struct {
+	lock     sync.Mutex
+	clients  map[*telemetryClient]bool
+	last     map[string][]byte
+	upgrader websocket.Upgrader
+}
+
+func NewTelemetryHub() *TelemetryHub {
+	return &TelemetryHub{
+		clients: make(map[*telemetryClient]bool),
+		last:    make(map[string][]byte),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+func (h *TelemetryHub) Publish(topic string, data interface{}) {
+	if h == nil {
+		return
+	}
+	msg, err := json.Marshal(TelemetryMessage{
+		Topic: topic,
+		Time:  time.Now(),
+		Data:  data,
+	})
+	if err != nil {
+		logger.Errorf("marshalling %s telemetry: %v", topic, err)
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.last[topic] = msg
+	for client := range h.clients {
+		if !client.subscribed(topic) {
+			continue
+		}
+		select {
+		case client.send <- msg:
+		default:
+		}
+	}
+}
+
+func (h *TelemetryHub) add(c *telemetryClient) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.clients[c] = true
+	h.sendLast(c, telemetryTopics)
+}
+
+// sendLast has to be called with lock held
+func (h *TelemetryHub) sendLast(c *telemetryClient, topics []string) {
+	for _, topic := range topics {
+		msg, ok := h.last[topic]
+		if !ok || !c.subscribed(topic) {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+		}
+	}
+}
+
+func (h *TelemetryHub) remove(c *telemetryClient) {
+	h.lock.Lock()
+	delete(h.clients, c)
+	h.lock.Unlock()
+}
+
+func (h *TelemetryHub) Clients() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.clients)
+}
+
+// ServeHTTP upgrades to a WebSocket, the query parameter topics=postits,slam selects the first topics, default are all
+func (h *TelemetryHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	client := &telemetryClient{
+		topics: make(map[string]bool),
+		send:   make(chan []byte, telemetryBuffer),
+	}
+	topics := telemetryTopics
+	if query := r.URL.Query().Get("topics"); query != "" {
+		topics = strings.Split(query, ",")
+	}
+	client.change(TelemetrySubscription{Subscribe: topics})
+	h.add(client)
+	logger.Debugf("Telemetry client %s connected", r.RemoteAddr)
+
+	done := make(chan bool)
+	go h.read(conn, client, done)
+	h.write(conn, client, done)
+
+	h.remove(client)
+	conn.Close()
+	logger.Debugf("Telemetry client %s disconnected", r.RemoteAddr)
+}
+
+// read changes the subscriptions until the connection is closed
+func (h *TelemetryHub) read(conn *websocket.Conn, client *telemetryClient, done chan bool) {
+	defer close(done)
+	for {
+		var s TelemetrySubscription
+		err := conn.ReadJSON(&s)
+		if err != nil {
+			switch err.(type) {
+			case *json.UnmarshalTypeError, *json.SyntaxError:
+				logger.Debugf("invalid telemetry subscription: %v", err)
+				continue
+			}
+			return
+		}
+		client.change(s)
+		h.lock.Lock()
+		h.sendLast(client, s.Subscribe)
+		h.lock.Unlock()
+	}
+}
+
+func (h *TelemetryHub) write(conn *websocket.Conn, client *telemetryClient, done chan bool) {
+	ping := time.NewTicker(telemetryPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case msg := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(telemetryWriteTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, msg)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(telemetryWriteTimeout))
+		case <-done:
+			return
+		}
+		if err != nil {
+			logger.Debugf("writing telemetry: %v", err)
+			return
+		}
+	}
+}
+
+// TrafficSignToPositionedObjects returns the detected traffic sign with its real position
+func TrafficSignToPositionedObjects(ts *TrafficSignFeature) PositionedObjects {
+	return PositionedObjects{{
+		X:          float32(ts.realPos.X),
+		Y:          float32(ts.realPos.Y),
+		ObjectType: ts.Name,
+	}}
+}
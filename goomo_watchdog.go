@@ -0,0 +1,248 @@
+package goomo
+
+//goomo_watchdog.go stops the Loomo when the camera frames, the features of the trackers or the decisions of the AI stall
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Sources of the Watchdog
+const (
+	WatchFrames   = "frames"
+	WatchFeatures = "features"
+	WatchAI       = "ai"
+)
+
+const (
+	defaultWatchdogThreshold = time.Second
+	watchdogInterval         = 100 * time.Millisecond
+)
+
+var ErrWatchdogStale = errors.New("a watched source is still stale, deactivate the modules depending on it to reset")
+
+// WatchdogFault is latched when a watched source did not beat within its threshold
+type WatchdogFault struct {
+	Source    string    `json:"source"`
+	Age       Duration  `json:"age"`
+	Threshold Duration  `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// WatchdogSource is a source in the WatchdogStatus, Age is only set while it is watched
+type WatchdogSource struct {
+	Name      string    `json:"name"`
+	Watched   bool      `json:"watched"`
+	Threshold Duration  `json:"threshold"`
+	Age       *Duration `json:"age,omitempty"`
+}
+
+// WatchdogStatus is the response of /watchdog
+type WatchdogStatus struct {
+	Faulted bool             `json:"faulted"`
+	Fault   *WatchdogFault   `json:"fault,omitempty"`
+	Sources []WatchdogSource `json:"sources"`
+}
+
+type watchedSource struct {
+	name      string
+	threshold time.Duration
+	watched   func() bool
+	active    bool
+	last      time.Time
+}
+
+// Watchdog checks that the watched sources beat within their thresholds. A source is only watched while its
+// predicate is true, e.g. while the AI which relies on it is active, and gets a full threshold when that starts.
+// A stale source latches a fault and calls OnFault once, the fault stays until Reset.
+type Watchdog struct {
+	// OnFault is called when a fault is latched, e.g. to stop the Loomo, and OnReset when it was cleared
+	OnFault func(fault WatchdogFault)
+	OnReset func()
+
+	lock      sync.Mutex
+	sources   []*watchedSource
+	fault     *WatchdogFault
+	telemetry *TelemetryHub
+	metrics   *moduleMetrics
+}
+
+func NewWatchdog() *Watchdog {
+	return &Watchdog{}
+}
+
+// Watch adds the source or replaces its threshold and predicate
+func (w *Watchdog) Watch(name string, threshold time.Duration, watched func() bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, s := range w.sources {
+		if s.name == name {
+			s.threshold = threshold
+			s.watched = watched
+			return
+		}
+	}
+	w.sources = append(w.sources, &watchedSource{name: name, threshold: threshold, watched: watched})
+}
+
+// Beat marks the source as fresh, it does nothing on a nil Watchdog so modules may be used without one
+func (w *Watchdog) Beat(name string) {
+	if w == nil {
+		return
+	}
+	now := time.Now()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, s := range w.sources {
+		if s.name == name {
+			s.last = now
+			return
+		}
+	}
+}
+
+// Faulted returns true while a fault is latched, the AIs do not drive until it is reset
+func (w *Watchdog) Faulted() bool {
+	if w == nil {
+		return false
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.fault != nil
+}
+
+// Start checks the sources every watchdogInterval until ctx is done
+func (w *Watchdog) Start(ctx context.Context) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+func (w *Watchdog) check(now time.Time) {
+	// the predicates ask the modules, so they are evaluated without holding the lock
+	w.lock.Lock()
+	sources := append([]*watchedSource(nil), w.sources...)
+	predicates := make([]func() bool, len(sources))
+	for i, s := range sources {
+		predicates[i] = s.watched
+	}
+	w.lock.Unlock()
+	watched := make([]bool, len(sources))
+	for i, predicate := range predicates {
+		watched[i] = predicate()
+	}
+
+	w.lock.Lock()
+	var fault *WatchdogFault
+	for i, s := range sources {
+		if !watched[i] {
+			s.active = false
+			continue
+		}
+		if !s.active {
+			s.active = true
+			s.last = now
+			continue
+		}
+		age := now.Sub(s.last)
+		if w.fault == nil && fault == nil && age > s.threshold {
+			fault = &WatchdogFault{
+				Source:    s.name,
+				Age:       Duration{age.Round(time.Millisecond)},
+				Threshold: Duration{s.threshold},
+				Time:      now,
+			}
+		}
+	}
+	if fault == nil {
+		w.lock.Unlock()
+		return
+	}
+	w.fault = fault
+	onFault := w.OnFault
+	w.lock.Unlock()
+
+	logger.Errorf("watchdog: %s did not beat for %v, stopping the Loomo until the watchdog is reset", fault.Source, fault.Age)
+	w.metrics.get().watchdogFaults.With(fault.Source).Inc()
+	w.telemetry.Publish(TopicWatchdog, w.Status())
+	if onFault != nil {
+		onFault(*fault)
+	}
+}
+
+// Reset clears the latched fault, which fails with ErrWatchdogStale while a watched source is stale
+func (w *Watchdog) Reset() error {
+	now := time.Now()
+	w.lock.Lock()
+	if w.fault == nil {
+		w.lock.Unlock()
+		return nil
+	}
+	for _, s := range w.sources {
+		if s.active && now.Sub(s.last) > s.threshold {
+			w.lock.Unlock()
+			return ErrWatchdogStale
+		}
+	}
+	w.fault = nil
+	onReset := w.OnReset
+	w.lock.Unlock()
+
+	logger.Info("watchdog reset")
+	w.telemetry.Publish(TopicWatchdog, w.Status())
+	if onReset != nil {
+		onReset()
+	}
+	return nil
+}
+
+func (w *Watchdog) Status() WatchdogStatus {
+	now := time.Now()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	status := WatchdogStatus{
+		Faulted: w.fault != nil,
+		Sources: make([]WatchdogSource, len(w.sources)),
+	}
+	if w.fault != nil {
+		fault := *w.fault
+		status.Fault = &fault
+	}
+	for i, s := range w.sources {
+		status.Sources[i] = WatchdogSource{
+			Name:      s.name,
+			Watched:   s.active,
+			Threshold: Duration{s.threshold},
+		}
+		if s.active {
+			status.Sources[i].Age = &Duration{now.Sub(s.last).Round(time.Millisecond)}
+		}
+	}
+	return status
+}
+
+// watch lets the watchdog stop the Loomo while an AI drives, the frames are needed by both AIs,
+// the features of the PostitTracker and the decisions only by the postit AI
+func (g *Goomo) watch() {
+	c := g.config.Watchdog
+	postits := func() bool { return g.modules.Active(PostitAIModule) }
+	g.watchdog.Watch(WatchFrames, c.Frames.Duration, func() bool {
+		return postits() || g.modules.Active(TrafficSignAIModule)
+	})
+	g.watchdog.Watch(WatchFeatures, c.Features.Duration, postits)
+	g.watchdog.Watch(WatchAI, c.AI.Duration, postits)
+}
+
+// Watchdog returns the watchdog which is served at /watchdog
+func (g *Goomo) Watchdog() *Watchdog {
+	return g.watchdog
+}
@@ -3,10 +3,13 @@ package goomo
 //lc.go is for defining methods of the LoomoCommunicator
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"net"
-	"strings"
+	"sort"
+	"sync"
+	"time"
 )
 
 type LoomoData struct {
@@ -14,109 +17,284 @@ type LoomoData struct {
 	data      []byte
 }
 
-func (l *LoomoCommunicator) receiveAddr() error {
-	c, err := net.ListenPacket("udp", l.BCport)
-	if err != nil {
-		return fmt.Errorf("listening for broadcast on Port %s: %v", l.BCport, err)
-	}
-	defer c.Close()
+// StreamData is a reassembled frame of the named stream, see AddDataListener
+type StreamData struct {
+	Stream string
+	Data   *LoomoData
+	// Time is when the frame was reassembled
+	Time time.Time
+}
 
-	buffer := make([]byte, 1024)
-	n, addr, err := c.ReadFrom(buffer)
-	if err != nil {
-		return fmt.Errorf("reading broadcast from Port %s: %v", l.BCport, err)
-	}
-	port := strings.TrimSuffix(string(buffer[:n]), "\n")
-	// this is dangerous because it only considers ipv4 addresses
-	ip := strings.Split(addr.String(), ":")[0]
-	l.loomoAddr = strings.Join([]string{ip, port}, ":")
-	return nil
+// IntakeCommand is a command handed to the LoomoCommunicator, see AddIntakeListener
+type IntakeCommand struct {
+	Command Command
+	// Time is when the command was handed over
+	Time time.Time
 }
 
+// IntakeListener is called with every command before it is queued and must not block
+type IntakeListener func(IntakeCommand)
+
 func (l *LoomoCommunicator) RegisterHandler(tag string, handler StreamDataHandler) (ok bool) {
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
 	l.handlers[tag] = handler
 	return true
 }
 
+func (l *LoomoCommunicator) hasHandler(stream string) bool {
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	_, ok := l.handlers["S"+stream]
+	return ok
+}
+
+// Connect discovers the Loomo and establishes the TCP connection once,
+// Start keeps it alive afterwards
 func (l *LoomoCommunicator) Connect() error {
+	return l.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect, which gives up discovering and dialling when ctx is done
+func (l *LoomoCommunicator) ConnectContext(ctx context.Context) error {
 	logger.Debug("Connecting to Loomo...")
-	err := l.receiveAddr()
+	err := l.receiveAddr(ctx)
 	if err != nil {
 		return fmt.Errorf("receiving Loomo address: %v", err)
 	}
-	tcpAddr, err := net.ResolveTCPAddr("tcp", l.loomoAddr)
+	l.connLock.Lock()
+	addr := l.loomoAddr
+	l.connLock.Unlock()
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("resolving TCP address '%s': %v", l.loomoAddr, err)
+		return fmt.Errorf("resolving TCP address '%s': %v", addr, err)
 	}
-	l.conn, err = net.DialTCP("tcp", nil, tcpAddr)
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, "tcp", tcpAddr.String())
 	if err != nil {
 		return fmt.Errorf("dialling TCP '%v': %v", tcpAddr, err)
 	}
-	logger.Debugf("Connected to Loomo: %v", l.conn)
+	conn := c.(*net.TCPConn)
+	l.connLock.Lock()
+	l.conn = conn
+	l.setState(Connected, 0, nil)
+	l.connLock.Unlock()
+	go l.watch(conn)
+	logger.Debugf("Connected to Loomo: %v", conn.RemoteAddr())
 	return nil
 }
 
 func (l *LoomoCommunicator) IsConnected() bool {
-	return l.conn != nil
+	return l.connection() != nil
 }
 
 func (l *LoomoCommunicator) Close() error {
-	return l.conn.Close()
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close()
+	l.conn = nil
+	l.setState(Disconnected, 0, nil)
+	return err
 }
 
+// ExecuteCommand sends cmd to the Loomo and waits at most CommandTimeout for the result
 func (l *LoomoCommunicator) ExecuteCommand(cmd Command) error {
-	l.Cmds <- cmd
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), l.CommandTimeout)
+	defer cancel()
+	return l.ExecuteCommandContext(ctx, cmd)
+}
+
+// ExecuteCommandContext sends cmd to the Loomo and returns a *CommandError if it could not be encoded or written.
+// If ctx is done before, ErrCommandTimeout is returned, but the command might still be sent afterwards.
+func (l *LoomoCommunicator) ExecuteCommandContext(ctx context.Context, cmd Command) error {
+	req := &commandRequest{
+		cmd:    cmd,
+		result: make(chan error, 1),
+	}
+	l.publishIntake(cmd)
+	err := l.scheduler.push(req)
+	if err != nil {
+		return &CommandError{cmd.Tag(), OpQueue, err}
+	}
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		if l.scheduler.cancel(req) {
+			return &CommandError{cmd.Tag(), OpQueue, ErrCommandTimeout}
+		}
+		return &CommandError{cmd.Tag(), OpWrite, ErrCommandTimeout}
+	}
 }
 
-func (l *LoomoCommunicator) packetWorker(id, port int) {
-	for p := range l.Streams[port].packets {
-		_, seq, tval, start, end := sensorHeaderHandler(p[:headerSize])
-		if l.Streams[port].unfinished[tval] == nil {
-			l.Streams[port].unfinished[tval] = make(map[uint32][]byte)
+// Stop sets both velocities to 0 ahead of all queued commands and drops the queued velocities.
+// If the stops cannot be queued, e.g. after the communicator stopped, the error is returned right away.
+func (l *LoomoCommunicator) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.CommandTimeout)
+	defer cancel()
+	lvReq := &commandRequest{&CLVLCommand{Lv: 0}, make(chan error, 1)}
+	avReq := &commandRequest{&CAVLCommand{Av: 0}, make(chan error, 1)}
+	l.publishIntake(lvReq.cmd)
+	l.publishIntake(avReq.cmd)
+	for _, req := range []*commandRequest{lvReq, avReq} {
+		if err := l.scheduler.push(req); err != nil {
+			return &CommandError{req.cmd.Tag(), OpQueue, err}
 		}
-		l.Streams[port].unfinished[tval][seq-start] = p[headerSize:]
-		if uint32(len(l.Streams[port].unfinished[tval])) == end-start+1 {
-			data := flattenData(l.Streams[port].unfinished[tval])
-			delete(l.Streams[port].unfinished, tval)
-			l.Streams[port].Data <- &LoomoData{
-				timestamp: tval,
-				data:      data,
+	}
+	var err error
+	for _, req := range []*commandRequest{lvReq, avReq} {
+		select {
+		case reqErr := <-req.result:
+			if err == nil {
+				err = reqErr
 			}
+		case <-ctx.Done():
+			return &CommandError{req.cmd.Tag(), OpWrite, ErrCommandTimeout}
 		}
 	}
+	return err
 }
 
-func (l *LoomoCommunicator) sensorHandler(port int, tag string) {
-	l.Streams[port] = NewSensorStream()
-	defer l.Streams[port].Close()
+// QueueStats returns the state of the command queue
+func (l *LoomoCommunicator) QueueStats() QueueStats {
+	return l.scheduler.Stats()
+}
 
-	for w := 1; w <= workerThreads; w++ {
-		go l.packetWorker(w, port)
+// SetHeadPosition turns the head to the absolute pitch and yaw angles in rad
+func (l *LoomoCommunicator) SetHeadPosition(pitch, yaw float32) error {
+	return l.ExecuteCommand(&CMHDCommand{Mode: HeadAngle, Vert: pitch, Hori: yaw})
+}
+
+// SetHeadVelocity turns the head with the angular velocities in rad/s until they are set to 0
+func (l *LoomoCommunicator) SetHeadVelocity(pitch, yaw float32) error {
+	return l.ExecuteCommand(&CMHDCommand{Mode: HeadRate, Vert: pitch, Hori: yaw})
+}
+
+func (l *LoomoCommunicator) packetWorker(id int, stream *SensorStream) {
+	for p := range stream.packets {
+		data, err := stream.assembler.add(p, time.Now())
+		if err != nil {
+			logger.Debugf("packet worker %d: %v", id, err)
+			continue
+		}
+		if data != nil {
+			l.metrics.get().lcFrames.With(stream.cmd.Stream).Inc()
+			l.publishData(stream.cmd.Stream, data)
+			stream.Data <- data
+		}
 	}
-	go l.handlers[tag].HandleStream(l.Streams[port], l.Cmds)
+}
 
-	var err error
-	l.Streams[port].Conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: []byte{0, 0, 0, 0}, Port: port, Zone: ""})
+// openStream listens for the packets of the stream requested by cmd
+// and passes them to the handler which is registered for its tag
+func (l *LoomoCommunicator) openStream(port int, cmd *CSSTCommand) error {
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	if _, ok := l.Streams[port]; ok {
+		return nil
+	}
+	tag := "S" + cmd.Stream
+	handler, ok := l.handlers[tag]
+	if !ok {
+		return fmt.Errorf("no handler registered for %s", tag)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: []byte{0, 0, 0, 0}, Port: port, Zone: ""})
 	if err != nil {
-		logger.Error("Listening UDP failed: %v", err.Error())
+		return fmt.Errorf("listening UDP on port %d: %v", port, err)
+	}
+	stream := NewSensorStream()
+	stream.assembler = newFrameAssembler(tag, l.FrameTimeout, l.MaxPendingFrames)
+	stream.Conn = conn
+	stream.cmd = cmd
+	l.Streams[port] = stream
+
+	go l.sensorHandler(port, stream, handler)
+	return nil
+}
+
+// closeStream stops listening on port, the handler is stopped by closing the data channel
+func (l *LoomoCommunicator) closeStream(port int) {
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	if stream, ok := l.Streams[port]; ok {
+		stream.Conn.Close()
+		delete(l.Streams, port)
+	}
+}
+
+// closeStreams stops listening on all ports, e.g. if their CEST could not be written on shutdown
+func (l *LoomoCommunicator) closeStreams() {
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	for port, stream := range l.Streams {
+		stream.Conn.Close()
+		delete(l.Streams, port)
+	}
+}
+
+// finalCommands stop the robot and every open stream, they are the last commands written on shutdown
+func (l *LoomoCommunicator) finalCommands() []*commandRequest {
+	final := []*commandRequest{{cmd: &CLVLCommand{Lv: 0}}, {cmd: &CAVLCommand{Av: 0}}}
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	ports := make([]int, 0, len(l.Streams))
+	for port := range l.Streams {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		cmd := l.Streams[port].cmd
+		final = append(final, &commandRequest{cmd: &CESTCommand{cmd.Port, cmd.Stream}})
 	}
-	defer l.Streams[port].Conn.Close()
+	return final
+}
+
+func (l *LoomoCommunicator) sensorHandler(port int, stream *SensorStream, handler StreamDataHandler) {
+	// without a worker the packets would never be read
+	threads := l.WorkerThreads
+	if threads < 1 {
+		threads = 1
+	}
+	workers := &sync.WaitGroup{}
+	for w := 1; w <= threads; w++ {
+		workers.Add(1)
+		go func(id int) {
+			l.packetWorker(id, stream)
+			workers.Done()
+		}(w)
+	}
+	go handler.HandleStream(stream, l.Cmds)
+
 	for {
 		buf := make([]byte, maxPacketSize)
-		n, _, err := l.Streams[port].Conn.ReadFromUDP(buf)
+		n, _, err := stream.Conn.ReadFromUDP(buf)
 		if err != nil {
-			logger.Error("Reading UDP failed:", err.Error())
+			logger.Debugf("Stopped reading UDP on port %d: %v", port, err)
 			break
 		}
-		l.Streams[port].packets <- buf[0:n]
+		stream.packets <- buf[0:n]
 	}
+	stream.Conn.Close()
+
+	// the workers have to be finished before the handler is stopped by closing Data
+	close(stream.packets)
+	workers.Wait()
+	close(stream.Data)
 }
 
-func (s *SensorStream) Close() {
-	close(s.Data)
-	s.unfinished = nil
-	close(s.packets)
+// StreamStats returns the packet statistics of every open stream by port
+func (l *LoomoCommunicator) StreamStats() map[int]StreamStats {
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	stats := make(map[int]StreamStats, len(l.Streams))
+	for port, stream := range l.Streams {
+		stats[port] = stream.assembler.Stats()
+	}
+	return stats
 }
 
 func sensorHeaderHandler(header []byte) (tag string, seq uint32, tval uint64, start uint32, end uint32) {
@@ -128,38 +306,172 @@ func sensorHeaderHandler(header []byte) (tag string, seq uint32, tval uint64, st
 	return
 }
 
-func (l *LoomoCommunicator) Start() error {
+// Start connects to the Loomo and keeps reconnecting in the background whenever the connection is lost.
+// Commands sent in the meantime fail with ErrNotConnected, but streams are requested again after reconnecting.
+// When ctx is done, the queued commands are dropped, zero velocities and a CEST for every open stream are written
+// and the connection is closed, see Wait. Later commands fail with ErrStopped.
+func (l *LoomoCommunicator) Start(ctx context.Context) error {
 	logger.Debug("Starting to take Loomo Commands")
+	go l.supervise(ctx)
+	l.scheduler.lock.Lock()
+	l.scheduler.interval = l.CommandInterval
+	l.scheduler.metrics = l.metrics.get()
+	l.scheduler.lock.Unlock()
 	go func() {
-		for cmd := range l.Cmds {
-			//log.Printf("Received Command %v", cmd)
-			msg, err := cmd.MsgFormat()
-			if err != nil {
-				logger.Error("cmd has error: %v", err)
+		for {
+			select {
+			case cmd, ok := <-l.Cmds:
+				if !ok {
+					l.scheduler.close()
+					return
+				}
+				l.publishIntake(cmd)
+				err := l.scheduler.push(&commandRequest{cmd: cmd})
+				if err != nil {
+					logger.Errorf("dropping %v: %v", cmd.Tag(), err)
+				}
+			case <-ctx.Done():
+				logger.Debug("Stopping the Loomo and its streams")
+				l.scheduler.shutdown(l.finalCommands())
+				return
 			}
-			switch cmd.Tag() {
-			case CSST:
-				port := int(binary.BigEndian.Uint32(msg[8:12]))
-				tag := "S" + string(msg[12:15])
-				go l.sensorHandler(port, tag)
-			case CEST:
-				port := int(binary.BigEndian.Uint32(msg[8:12]))
-				l.Streams[port].Conn.Close()
+		}
+	}()
+	go func() {
+		for {
+			req, ok := l.scheduler.next()
+			if !ok {
+				l.closeStreams()
+				l.Close()
+				close(l.done)
+				return
 			}
-			//log.Printf("writing: %x", msg)
-			_, err = l.conn.Write(msg)
-			//log.Println("Wrote", n, "bytes")
+			start := time.Now()
+			err := l.send(req.cmd)
+			l.metrics.get().lcCommandSeconds.With().Since(start)
+			result := "sent"
 			if err != nil {
-				logger.Error("writing to connection: %v", err)
-				continue
+				result = "failed"
+			}
+			l.metrics.get().lcCommands.With(req.cmd.Tag().String(), result).Inc()
+			l.scheduler.sent(err)
+			if err != nil && req.result == nil {
+				logger.Error(err)
 			}
+			req.resolve(err)
 		}
-		l.done <- true
 	}()
 	logger.Debug("StartedLoomo Commands")
 	return nil
 }
 
+func (l *LoomoCommunicator) send(cmd Command) error {
+	//log.Printf("Received Command %v", cmd)
+	msg, err := cmd.MsgFormat()
+	if err != nil {
+		return &CommandError{cmd.Tag(), OpEncode, err}
+	}
+	switch c := cmd.(type) {
+	case *CSSTCommand:
+		port := int(binary.BigEndian.Uint32(msg[8:12]))
+		err = l.openStream(port, c)
+		if err != nil {
+			return &CommandError{cmd.Tag(), OpStream, err}
+		}
+	case *CESTCommand:
+		port := int(binary.BigEndian.Uint32(msg[8:12]))
+		l.closeStream(port)
+	}
+	conn := l.connection()
+	if conn == nil {
+		return &CommandError{cmd.Tag(), OpWrite, ErrNotConnected}
+	}
+	//log.Printf("writing: %x", msg)
+	_, err = conn.Write(msg)
+	//log.Println("Wrote", n, "bytes")
+	if err != nil {
+		l.connectionLost(conn, err)
+		return &CommandError{cmd.Tag(), OpWrite, err}
+	}
+	l.publishCommand(cmd)
+	return nil
+}
+
+// AddCommandListener registers a channel which receives every command after it was written to the Loomo,
+// commands are dropped if the receiver is not ready
+func (l *LoomoCommunicator) AddCommandListener(id string, receiver chan Command) {
+	l.connLock.Lock()
+	l.cmdListeners[id] = receiver
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) RemoveCommandListener(id string) {
+	l.connLock.Lock()
+	delete(l.cmdListeners, id)
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) publishCommand(cmd Command) {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	for _, listener := range l.cmdListeners {
+		select {
+		case listener <- cmd:
+		default:
+		}
+	}
+}
+
+// AddIntakeListener registers a listener which is called with every command of Cmds, ExecuteCommand and Stop
+// before it is queued, including the ones which are coalesced, rejected or fail later
+func (l *LoomoCommunicator) AddIntakeListener(id string, listener IntakeListener) {
+	l.connLock.Lock()
+	l.intakeListeners[id] = listener
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) RemoveIntakeListener(id string) {
+	l.connLock.Lock()
+	delete(l.intakeListeners, id)
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) publishIntake(cmd Command) {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	intake := IntakeCommand{cmd, time.Now()}
+	for _, listener := range l.intakeListeners {
+		listener(intake)
+	}
+}
+
+// AddDataListener registers a channel which receives every reassembled frame of all streams,
+// frames are dropped if the receiver is not ready
+func (l *LoomoCommunicator) AddDataListener(id string, receiver chan StreamData) {
+	l.connLock.Lock()
+	l.dataListeners[id] = receiver
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) RemoveDataListener(id string) {
+	l.connLock.Lock()
+	delete(l.dataListeners, id)
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) publishData(stream string, data *LoomoData) {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	now := time.Now()
+	for _, listener := range l.dataListeners {
+		select {
+		case listener <- StreamData{stream, data, now}:
+		default:
+		}
+	}
+}
+
+// Wait blocks until the communicator stopped and closed the connection
 func (l *LoomoCommunicator) Wait() {
 	<-l.done
 }
@@ -102,7 +102,36 @@ func (c *CAVLCommand) MsgFormat() ([]byte, error) {
 	return req, nil
 }
 
+// HeadMode specifies how the values of a CMHDCommand are interpreted
+type HeadMode uint8
+
+const (
+	// HeadAngle moves the head to absolute angles in rad
+	HeadAngle HeadMode = iota
+	// HeadRate turns the head with angular velocities in rad/s
+	HeadRate
+)
+
+// CMHDCommand moves the head of the Loomo, Vert is the pitch and Hori the yaw
 type CMHDCommand struct {
-	vert float32
-	hori float32
+	Mode HeadMode
+	Vert float32
+	Hori float32
+}
+
+func (c *CMHDCommand) Tag() CommandTag {
+	return CHMD
+}
+
+func (c *CMHDCommand) MsgFormat() ([]byte, error) {
+	if c.Mode != HeadAngle && c.Mode != HeadRate {
+		return nil, fmt.Errorf("unknown head mode %d", c.Mode)
+	}
+	req := make([]byte, 17)
+	copy(req[0:4], "CHMD")
+	binary.BigEndian.PutUint32(req[4:8], 17)
+	req[8] = byte(c.Mode)
+	binary.BigEndian.PutUint32(req[9:13], math.Float32bits(c.Vert))
+	binary.BigEndian.PutUint32(req[13:17], math.Float32bits(c.Hori))
+	return req, nil
 }
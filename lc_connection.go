@@ -0,0 +1,196 @@
+package goomo
+
+//lc_connection.go supervises the TCP connection of the LoomoCommunicator
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// ConnectionState is the state of the TCP connection to the Loomo
+type ConnectionState uint8
+
+const (
+	Disconnected ConnectionState = iota
+	Connecting
+	Connected
+	ConnectionLost
+)
+
+var connectionStateNames = map[ConnectionState]string{
+	Disconnected:   "disconnected",
+	Connecting:     "connecting",
+	Connected:      "connected",
+	ConnectionLost: "lost",
+}
+
+func (s ConnectionState) String() string {
+	return connectionStateNames[s]
+}
+
+func (s ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ConnectionEvent is published to all state listeners whenever the connection state changes
+type ConnectionEvent struct {
+	State    ConnectionState `json:"state"`
+	Addr     string          `json:"addr,omitempty"`
+	Attempts int             `json:"attempts,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// AddStateListener registers a channel which receives every ConnectionEvent,
+// events are dropped if the receiver is not ready
+func (l *LoomoCommunicator) AddStateListener(id string, receiver chan ConnectionEvent) {
+	l.connLock.Lock()
+	l.stateListeners[id] = receiver
+	l.connLock.Unlock()
+}
+
+func (l *LoomoCommunicator) RemoveStateListener(id string) {
+	l.connLock.Lock()
+	delete(l.stateListeners, id)
+	l.connLock.Unlock()
+}
+
+// ConnectionStatus returns the last ConnectionEvent
+func (l *LoomoCommunicator) ConnectionStatus() ConnectionEvent {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	return l.status
+}
+
+// setState has to be called with connLock held
+func (l *LoomoCommunicator) setState(state ConnectionState, attempts int, err error) {
+	l.status = ConnectionEvent{
+		State:    state,
+		Addr:     l.loomoAddr,
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		l.status.Error = err.Error()
+	}
+	for _, listener := range l.stateListeners {
+		select {
+		case listener <- l.status:
+		default:
+		}
+	}
+}
+
+func (l *LoomoCommunicator) connection() *net.TCPConn {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	return l.conn
+}
+
+// watch reads from the connection until it breaks, the Loomo does not send anything we need
+func (l *LoomoCommunicator) watch(conn *net.TCPConn) {
+	_, err := io.Copy(ioutil.Discard, conn)
+	if err == nil {
+		err = io.EOF
+	}
+	l.connectionLost(conn, err)
+}
+
+// connectionLost closes conn and wakes up the supervisor if conn is still the current connection
+func (l *LoomoCommunicator) connectionLost(conn *net.TCPConn, err error) {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	if l.conn != conn {
+		return
+	}
+	logger.Errorf("lost connection to Loomo: %v", err)
+	l.conn.Close()
+	l.conn = nil
+	l.setState(ConnectionLost, 0, err)
+	select {
+	case l.lost <- true:
+	default:
+	}
+}
+
+// supervise keeps the connection to the Loomo alive until ctx is done: after losing it the address is discovered again,
+// the connection is re-established with exponential backoff and all open streams are requested again
+func (l *LoomoCommunicator) supervise(ctx context.Context) {
+	for {
+		if !l.IsConnected() {
+			if !l.reconnect(ctx) {
+				return
+			}
+			l.resumeStreams()
+		}
+		select {
+		case <-l.lost:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconnect returns false if ctx was done before the connection was established
+func (l *LoomoCommunicator) reconnect(ctx context.Context) bool {
+	backoff := l.MinBackoff
+	for attempt := 1; ; attempt++ {
+		l.connLock.Lock()
+		l.setState(Connecting, attempt, nil)
+		l.connLock.Unlock()
+
+		err := l.ConnectContext(ctx)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			l.connLock.Lock()
+			l.setState(Disconnected, 0, nil)
+			l.connLock.Unlock()
+			return false
+		}
+		logger.Errorf("connecting to Loomo (attempt %d, retrying in %v): %v", attempt, backoff, err)
+		l.connLock.Lock()
+		l.setState(Connecting, attempt, err)
+		l.connLock.Unlock()
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			l.connLock.Lock()
+			l.setState(Disconnected, 0, nil)
+			l.connLock.Unlock()
+			return false
+		}
+		backoff *= 2
+		if backoff > l.MaxBackoff {
+			backoff = l.MaxBackoff
+		}
+	}
+}
+
+func (l *LoomoCommunicator) resumeStreams() {
+	conn := l.connection()
+	if conn == nil {
+		return
+	}
+	l.streamsLock.Lock()
+	defer l.streamsLock.Unlock()
+	for port, stream := range l.Streams {
+		msg, err := stream.cmd.MsgFormat()
+		if err != nil {
+			logger.Errorf("resuming stream on port %d: %v", port, err)
+			continue
+		}
+		_, err = conn.Write(msg)
+		if err != nil {
+			logger.Errorf("resuming stream on port %d: %v", port, err)
+			l.connectionLost(conn, err)
+			return
+		}
+		logger.Debugf("Resumed stream %s on port %d", stream.cmd.Stream, port)
+	}
+}
@@ -0,0 +1,167 @@
+package goomo
+
+//lc_discovery.go finds the address of the Loomo, which announces its TCP port via UDP broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDiscoveryTimeout = 10 * time.Second
+
+var ErrDiscoveryTimeout = errors.New("no matching Loomo announced itself before the discovery timeout")
+
+// LoomoAnnouncement is a broadcast which was received from a Loomo.
+// The payload is the TCP port, optionally followed by the serial of the robot: "<port>[ <serial>]\n"
+type LoomoAnnouncement struct {
+	Addr   string    `json:"addr"`
+	Serial string    `json:"serial,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func parseAnnouncement(payload []byte, from net.Addr) (LoomoAnnouncement, error) {
+	fields := strings.Fields(string(payload))
+	if len(fields) == 0 || len(fields) > 2 {
+		return LoomoAnnouncement{}, fmt.Errorf("malformed announcement %q from %v", payload, from)
+	}
+	port, err := strconv.Atoi(fields[0])
+	if err != nil || port <= 0 || port > 65535 {
+		return LoomoAnnouncement{}, fmt.Errorf("invalid port %q announced by %v", fields[0], from)
+	}
+	udpAddr, ok := from.(*net.UDPAddr)
+	if !ok {
+		return LoomoAnnouncement{}, fmt.Errorf("announcement from non UDP address %v", from)
+	}
+	// the zone is needed to dial link-local IPv6 addresses
+	tcpAddr := net.TCPAddr{IP: udpAddr.IP, Port: port, Zone: udpAddr.Zone}
+
+	a := LoomoAnnouncement{
+		Addr: tcpAddr.String(),
+		Time: time.Now(),
+	}
+	if len(fields) == 2 {
+		a.Serial = fields[1]
+	}
+	return a, nil
+}
+
+// matches reports whether the announcement belongs to the Loomo with the serial, IP address or host:port target
+func (a LoomoAnnouncement) matches(target string) bool {
+	if target == "" || target == a.Serial || target == a.Addr {
+		return true
+	}
+	host, _, err := net.SplitHostPort(a.Addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(strings.Trim(target, "[]"))
+	return ip != nil && ip.Equal(net.ParseIP(strings.SplitN(host, "%", 2)[0]))
+}
+
+// discoveryLock serializes the communicators of a Fleet, which cannot listen on the same broadcast port at once
+var discoveryLock sync.Mutex
+
+// listenAnnouncements calls handle for every valid announcement on bcPort until it returns true, the timeout expires or ctx is done.
+// A timeout of 0 waits forever.
+func listenAnnouncements(ctx context.Context, bcPort string, timeout time.Duration, handle func(LoomoAnnouncement) bool) error {
+	discoveryLock.Lock()
+	defer discoveryLock.Unlock()
+
+	c, err := net.ListenPacket("udp", bcPort)
+	if err != nil {
+		return fmt.Errorf("listening for broadcast on Port %s: %v", bcPort, err)
+	}
+	defer c.Close()
+	// closing the connection ends a blocked ReadFrom
+	finished := make(chan bool)
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-finished:
+		}
+	}()
+
+	if timeout > 0 {
+		err = c.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	buffer := make([]byte, 1024)
+	for {
+		n, addr, err := c.ReadFrom(buffer)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return ErrDiscoveryTimeout
+			}
+			return fmt.Errorf("reading broadcast from Port %s: %v", bcPort, err)
+		}
+		a, err := parseAnnouncement(buffer[:n], addr)
+		if err != nil {
+			logger.Debug(err)
+			continue
+		}
+		if handle(a) {
+			return nil
+		}
+	}
+}
+
+// DiscoverLoomos lists every Loomo which announced itself on bcPort within the timeout
+func DiscoverLoomos(bcPort string, timeout time.Duration) ([]LoomoAnnouncement, error) {
+	if timeout <= 0 {
+		return nil, errors.New("listing Loomos needs a positive timeout")
+	}
+	found := make(map[string]LoomoAnnouncement)
+	err := listenAnnouncements(context.Background(), bcPort, timeout, func(a LoomoAnnouncement) bool {
+		found[a.Addr] = a
+		return false
+	})
+	if err != nil && err != ErrDiscoveryTimeout {
+		return nil, err
+	}
+
+	announcements := make([]LoomoAnnouncement, 0, len(found))
+	for _, a := range found {
+		announcements = append(announcements, a)
+	}
+	sort.Slice(announcements, func(i, j int) bool {
+		return announcements[i].Addr < announcements[j].Addr
+	})
+	return announcements, nil
+}
+
+func (l *LoomoCommunicator) receiveAddr(ctx context.Context) error {
+	addr := l.Addr
+	if addr == "" {
+		err := listenAnnouncements(ctx, l.BCport, l.DiscoveryTimeout, func(a LoomoAnnouncement) bool {
+			if !a.matches(l.Target) {
+				logger.Debugf("Ignoring Loomo %s (%s), looking for %q", a.Addr, a.Serial, l.Target)
+				return false
+			}
+			addr = a.Addr
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	l.connLock.Lock()
+	l.loomoAddr = addr
+	l.connLock.Unlock()
+	return nil
+}
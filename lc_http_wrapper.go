@@ -1,18 +1,207 @@
 package goomo
 
-import "net/http"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
+// HTTPLoomoCommunicator sends any Command which is posted as JSON to /command
 type HTTPLoomoCommunicator struct {
-	LoomoCommunicator
+	*LoomoCommunicator
 }
 
 /*
 Command JSON Structure:
-{
 
-}
+	{
+		"tag": "CSST" | "CEST" | "CSPS" | "CLVL" | "CAVL" | "CHMD",
+		"port": string,              // CSST, CEST
+		"stream": string,            // CSST, CEST, e.g. "CAM"
+		"x": float, "y": float,      // CSPS
+		"lv": float,                 // CLVL in m/s
+		"av": float,                 // CAVL in rad/s
+		"mode": "angle" | "rate",    // CHMD
+		"pitch": float, "yaw": float // CHMD in rad or rad/s
+	}
+
+Fields which do not belong to the tag are rejected.
 */
+type CommandRequest struct {
+	Tag    string   `json:"tag"`
+	Port   *string  `json:"port,omitempty"`
+	Stream *string  `json:"stream,omitempty"`
+	X      *float32 `json:"x,omitempty"`
+	Y      *float32 `json:"y,omitempty"`
+	Lv     *float32 `json:"lv,omitempty"`
+	Av     *float32 `json:"av,omitempty"`
+	Mode   *string  `json:"mode,omitempty"`
+	Pitch  *float32 `json:"pitch,omitempty"`
+	Yaw    *float32 `json:"yaw,omitempty"`
+}
+
+// CommandOutcome is the response of /command
+type CommandOutcome struct {
+	Tag  string `json:"tag,omitempty"`
+	Sent bool   `json:"sent"`
+	// Accepted is set instead of Sent if the MotionShaper took the velocity as target and sends it ramped
+	Accepted bool   `json:"accepted,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var commandFields = map[CommandTag][]string{
+	CSST: {"port", "stream"},
+	CEST: {"port", "stream"},
+	CSPS: {"x", "y"},
+	CLVL: {"lv"},
+	CAVL: {"av"},
+	CHMD: {"mode", "pitch", "yaw"},
+}
+
+func (cr *CommandRequest) fields() map[string]bool {
+	set := map[string]bool{}
+	for name, present := range map[string]bool{
+		"port": cr.Port != nil, "stream": cr.Stream != nil,
+		"x": cr.X != nil, "y": cr.Y != nil,
+		"lv": cr.Lv != nil, "av": cr.Av != nil,
+		"mode": cr.Mode != nil, "pitch": cr.Pitch != nil, "yaw": cr.Yaw != nil,
+	} {
+		if present {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+func validFloat(name string, v float32) error {
+	if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+		return fmt.Errorf("%s has to be a finite number", name)
+	}
+	return nil
+}
+
+func validPort(port string) error {
+	p, err := strconv.Atoi(strings.TrimPrefix(port, ":"))
+	if err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// Command validates the request and returns the Command it describes
+func (cr *CommandRequest) Command() (Command, error) {
+	tag, ok := ParseCommandTag(cr.Tag)
+	if !ok {
+		return nil, fmt.Errorf("unknown tag %q", cr.Tag)
+	}
+	present := cr.fields()
+	for _, name := range commandFields[tag] {
+		if !present[name] {
+			return nil, fmt.Errorf("%s needs the field %q", cr.Tag, name)
+		}
+		delete(present, name)
+	}
+	for name := range present {
+		return nil, fmt.Errorf("%s has no field %q", cr.Tag, name)
+	}
+
+	switch tag {
+	case CSST, CEST:
+		if err := validPort(*cr.Port); err != nil {
+			return nil, err
+		}
+		if len(*cr.Stream) != 3 {
+			return nil, fmt.Errorf("stream has to be 3 characters long, e.g. %q", CameraStream)
+		}
+		if tag == CSST {
+			return &CSSTCommand{*cr.Port, *cr.Stream}, nil
+		}
+		return &CESTCommand{*cr.Port, *cr.Stream}, nil
+	case CSPS:
+		for name, v := range map[string]float32{"x": *cr.X, "y": *cr.Y} {
+			if err := validFloat(name, v); err != nil {
+				return nil, err
+			}
+		}
+		return &CSPSCommand{*cr.X, *cr.Y}, nil
+	case CLVL:
+		if err := validFloat("lv", *cr.Lv); err != nil {
+			return nil, err
+		}
+		return &CLVLCommand{Lv: *cr.Lv}, nil
+	case CAVL:
+		if err := validFloat("av", *cr.Av); err != nil {
+			return nil, err
+		}
+		return &CAVLCommand{Av: *cr.Av}, nil
+	case CHMD:
+		for name, v := range map[string]float32{"pitch": *cr.Pitch, "yaw": *cr.Yaw} {
+			if err := validFloat(name, v); err != nil {
+				return nil, err
+			}
+		}
+		switch *cr.Mode {
+		case "angle":
+			return &CMHDCommand{Mode: HeadAngle, Vert: *cr.Pitch, Hori: *cr.Yaw}, nil
+		case "rate":
+			return &CMHDCommand{Mode: HeadRate, Vert: *cr.Pitch, Hori: *cr.Yaw}, nil
+		}
+		return nil, fmt.Errorf("mode has to be \"angle\" or \"rate\"")
+	}
+	return nil, fmt.Errorf("%s cannot be sent via HTTP", cr.Tag)
+}
+
+func writeOutcome(w http.ResponseWriter, status int, outcome CommandOutcome) {
+	responseJSON, err := json.Marshal(outcome)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+func (hlc *HTTPLoomoCommunicator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hlc.executeCommandViaHTTP(w, r)
+}
 
 func (hlc *HTTPLoomoCommunicator) executeCommandViaHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		writeOutcome(w, http.StatusMethodNotAllowed, CommandOutcome{Error: "method has to be POST or PUT"})
+		return
+	}
+	if r.Body == nil {
+		writeOutcome(w, http.StatusBadRequest, CommandOutcome{Error: "Please send a request body"})
+		return
+	}
+	var cr CommandRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&cr)
+	if err != nil {
+		writeOutcome(w, http.StatusBadRequest, CommandOutcome{Error: err.Error()})
+		return
+	}
+	cmd, err := cr.Command()
+	if err != nil {
+		writeOutcome(w, http.StatusBadRequest, CommandOutcome{Tag: cr.Tag, Error: err.Error()})
+		return
+	}
+	if c, ok := cmd.(*CSSTCommand); ok && !hlc.hasHandler(c.Stream) {
+		writeOutcome(w, http.StatusBadRequest, CommandOutcome{Tag: cr.Tag, Error: "no handler registered for stream " + c.Stream})
+		return
+	}
 
+	err = hlc.ExecuteCommand(cmd)
+	if err != nil {
+		logger.Error(err)
+		writeOutcome(w, commandStatus(err), CommandOutcome{Tag: cr.Tag, Error: err.Error()})
+		return
+	}
+	writeOutcome(w, http.StatusOK, CommandOutcome{Tag: cr.Tag, Sent: true})
 }
@@ -0,0 +1,114 @@
+package goomo
+
+//lc_parse.go decodes the messages which are encoded by the MsgFormat methods in lc_commands.go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// CommandParseError is returned for messages which are framed correctly but cannot be decoded.
+// A CommandReader can still be used after returning it.
+type CommandParseError struct {
+	Tag    string
+	Reason string
+}
+
+func (e *CommandParseError) Error() string {
+	return fmt.Sprintf("parsing command %q: %s", e.Tag, e.Reason)
+}
+
+var commandLengths = map[CommandTag]int{
+	CSST: 15,
+	CEST: 15,
+	CSPS: 16,
+	CLVL: 12,
+	CAVL: 12,
+	CHMD: 17,
+}
+
+// ParseCommand decodes a single message including its 4 byte tag and 4 byte length prefix
+func ParseCommand(msg []byte) (Command, error) {
+	if len(msg) < 8 {
+		return nil, fmt.Errorf("command of %d bytes is shorter than its header", len(msg))
+	}
+	name := string(msg[0:4])
+	length := binary.BigEndian.Uint32(msg[4:8])
+	if int(length) != len(msg) {
+		return nil, &CommandParseError{name, fmt.Sprintf("length prefix %d does not match message length %d", length, len(msg))}
+	}
+
+	tag, ok := ParseCommandTag(name)
+	if !ok {
+		return nil, &CommandParseError{name, "unknown tag"}
+	}
+	if want, ok := commandLengths[tag]; ok && len(msg) != want {
+		return nil, &CommandParseError{name, fmt.Sprintf("length %d, want %d", len(msg), want)}
+	}
+
+	switch tag {
+	case CSST:
+		return &CSSTCommand{Port: parsePort(msg[8:12]), Stream: string(msg[12:15])}, nil
+	case CEST:
+		return &CESTCommand{Port: parsePort(msg[8:12]), Stream: string(msg[12:15])}, nil
+	case CSPS:
+		return &CSPSCommand{X: parseFloat32(msg[8:12]), Y: parseFloat32(msg[12:16])}, nil
+	case CLVL:
+		return &CLVLCommand{Lv: parseFloat32(msg[8:12])}, nil
+	case CAVL:
+		return &CAVLCommand{Av: parseFloat32(msg[8:12])}, nil
+	case CHMD:
+		mode := HeadMode(msg[8])
+		if mode != HeadAngle && mode != HeadRate {
+			return nil, &CommandParseError{name, fmt.Sprintf("unknown head mode %d", mode)}
+		}
+		return &CMHDCommand{Mode: mode, Vert: parseFloat32(msg[9:13]), Hori: parseFloat32(msg[13:17])}, nil
+	default:
+		return nil, &CommandParseError{name, "decoding is not supported"}
+	}
+}
+
+func parsePort(b []byte) string {
+	return strconv.Itoa(int(binary.BigEndian.Uint32(b)))
+}
+
+func parseFloat32(b []byte) float32 {
+	return math.Float32frombits(binary.BigEndian.Uint32(b))
+}
+
+// CommandReader reads length prefixed commands from a byte stream, e.g. the TCP connection to the Loomo
+type CommandReader struct {
+	r      io.Reader
+	header []byte
+}
+
+func NewCommandReader(r io.Reader) *CommandReader {
+	return &CommandReader{
+		r:      r,
+		header: make([]byte, 8),
+	}
+}
+
+// ReadCommand returns the next command of the stream.
+// Errors other than *CommandParseError mean that the stream cannot be read any further.
+func (c *CommandReader) ReadCommand() (Command, error) {
+	_, err := io.ReadFull(c.r, c.header)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(c.header[4:8])
+	if length < 8 || length > maxCommandSize {
+		return nil, fmt.Errorf("invalid length %d for command %q", length, c.header[0:4])
+	}
+
+	msg := make([]byte, length)
+	copy(msg, c.header)
+	_, err = io.ReadFull(c.r, msg[8:])
+	if err != nil {
+		return nil, fmt.Errorf("reading body of command %q: %v", c.header[0:4], err)
+	}
+	return ParseCommand(msg)
+}
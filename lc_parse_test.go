@@ -0,0 +1,38 @@
+package goomo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandRoundTrip(t *testing.T) {
+	cmds := []Command{
+		&CSSTCommand{Port: "45678", Stream: CameraStream},
+		&CESTCommand{Port: "45678", Stream: CameraStream},
+		&CSPSCommand{X: 1.5, Y: -2.25},
+		&CLVLCommand{Lv: 0.25},
+		&CAVLCommand{Av: -0.5},
+		&CMHDCommand{Mode: HeadAngle, Vert: 0.5, Hori: -1},
+		&CMHDCommand{Mode: HeadRate, Vert: -0.1, Hori: 0.2},
+	}
+	tested := make(map[CommandTag]bool)
+	for _, cmd := range cmds {
+		msg, err := cmd.MsgFormat()
+		if err != nil {
+			t.Fatalf("encoding %v: %v", cmd.Tag(), err)
+		}
+		parsed, err := ParseCommand(msg)
+		if err != nil {
+			t.Fatalf("parsing %v: %v", cmd.Tag(), err)
+		}
+		if !reflect.DeepEqual(parsed, cmd) {
+			t.Errorf("parsed %v as %+v, want %+v", cmd.Tag(), parsed, cmd)
+		}
+		tested[cmd.Tag()] = true
+	}
+	for tag := range commandTagNames {
+		if !tested[tag] {
+			t.Errorf("%v is not round-tripped", tag)
+		}
+	}
+}
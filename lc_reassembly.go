@@ -0,0 +1,191 @@
+package goomo
+
+//lc_reassembly.go puts the UDP packets of a sensor stream back together to frames
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFrameTimeout     = 500 * time.Millisecond
+	defaultMaxPendingFrames = 32
+	// shorter timeouts would drop frames whose packets are still arriving
+	minFrameTimeout = 50 * time.Millisecond
+	// a 640x480 JPG never needs more packets than that
+	maxFrameChunks = 512
+	// timestamps of completed frames are remembered to recognize late duplicates
+	completedHistory = 16
+)
+
+// StreamStats counts what happened to the packets of a SensorStream
+type StreamStats struct {
+	CompletedFrames   uint64 `json:"completedFrames"`
+	DroppedFrames     uint64 `json:"droppedFrames"`
+	DuplicatePackets  uint64 `json:"duplicatePackets"`
+	OutOfOrderPackets uint64 `json:"outOfOrderPackets"`
+	InvalidPackets    uint64 `json:"invalidPackets"`
+	PendingFrames     int    `json:"pendingFrames"`
+}
+
+type partialFrame struct {
+	start     uint32
+	end       uint32
+	chunks    [][]byte
+	received  int
+	size      int
+	firstSeen time.Time
+}
+
+// frameAssembler is safe to be used by several packet workers of the same stream.
+// Incomplete frames are dropped after timeout and at most maxPending frames are kept at once.
+type frameAssembler struct {
+	tag        string
+	timeout    time.Duration
+	maxPending int
+
+	lock      sync.Mutex
+	pending   map[uint64]*partialFrame
+	completed [completedHistory]uint64
+	nextDone  int
+	lastSeq   uint32
+	seenSeq   bool
+	lastSweep time.Time
+	stats     StreamStats
+}
+
+// newFrameAssembler keeps at least one pending frame for at least minFrameTimeout,
+// otherwise a frame could never be completed
+func newFrameAssembler(tag string, timeout time.Duration, maxPending int) *frameAssembler {
+	if maxPending < 1 {
+		maxPending = 1
+	}
+	if timeout < minFrameTimeout {
+		timeout = minFrameTimeout
+	}
+	return &frameAssembler{
+		tag:        tag,
+		timeout:    timeout,
+		maxPending: maxPending,
+		pending:    make(map[uint64]*partialFrame),
+	}
+}
+
+// add returns the frame if p was its last missing packet and nil otherwise
+func (a *frameAssembler) add(p []byte, now time.Time) (*LoomoData, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if now.Sub(a.lastSweep) > a.timeout/2 {
+		a.evictExpired(now)
+		a.lastSweep = now
+	}
+
+	if len(p) < headerSize {
+		a.stats.InvalidPackets++
+		return nil, fmt.Errorf("packet of %d bytes is shorter than its header", len(p))
+	}
+	tag, seq, tval, start, end := sensorHeaderHandler(p[:headerSize])
+	if tag != a.tag {
+		a.stats.InvalidPackets++
+		return nil, fmt.Errorf("packet with tag %q in stream %s", tag, a.tag)
+	}
+	if end < start || seq < start || seq > end || end-start >= maxFrameChunks {
+		a.stats.InvalidPackets++
+		return nil, fmt.Errorf("packet %d outside of frame [%d, %d]", seq, start, end)
+	}
+
+	if a.seenSeq && seq < a.lastSeq {
+		a.stats.OutOfOrderPackets++
+	} else {
+		a.lastSeq = seq
+		a.seenSeq = true
+	}
+
+	if a.isCompleted(tval) {
+		a.stats.DuplicatePackets++
+		return nil, nil
+	}
+
+	frame, ok := a.pending[tval]
+	if !ok {
+		if len(a.pending) >= a.maxPending {
+			a.evictOldest()
+		}
+		frame = &partialFrame{
+			start:     start,
+			end:       end,
+			chunks:    make([][]byte, end-start+1),
+			firstSeen: now,
+		}
+		a.pending[tval] = frame
+	} else if frame.start != start || frame.end != end {
+		a.stats.InvalidPackets++
+		return nil, fmt.Errorf("packet %d claims frame [%d, %d], but frame %d is [%d, %d]", seq, start, end, tval, frame.start, frame.end)
+	}
+
+	i := seq - start
+	if frame.chunks[i] != nil {
+		a.stats.DuplicatePackets++
+		return nil, nil
+	}
+	frame.chunks[i] = p[headerSize:]
+	frame.received++
+	frame.size += len(p) - headerSize
+	if frame.received < len(frame.chunks) {
+		return nil, nil
+	}
+
+	delete(a.pending, tval)
+	a.completed[a.nextDone] = tval
+	a.nextDone = (a.nextDone + 1) % completedHistory
+	a.stats.CompletedFrames++
+
+	data := make([]byte, 0, frame.size)
+	for _, chunk := range frame.chunks {
+		data = append(data, chunk...)
+	}
+	return &LoomoData{
+		timestamp: tval,
+		data:      data,
+	}, nil
+}
+
+func (a *frameAssembler) isCompleted(tval uint64) bool {
+	for i := 0; i < completedHistory && uint64(i) < a.stats.CompletedFrames; i++ {
+		if a.completed[i] == tval {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *frameAssembler) evictExpired(now time.Time) {
+	for tval, frame := range a.pending {
+		if now.Sub(frame.firstSeen) > a.timeout {
+			delete(a.pending, tval)
+			a.stats.DroppedFrames++
+		}
+	}
+}
+
+func (a *frameAssembler) evictOldest() {
+	var oldest uint64
+	var oldestSeen time.Time
+	for tval, frame := range a.pending {
+		if oldestSeen.IsZero() || frame.firstSeen.Before(oldestSeen) {
+			oldest, oldestSeen = tval, frame.firstSeen
+		}
+	}
+	delete(a.pending, oldest)
+	a.stats.DroppedFrames++
+}
+
+func (a *frameAssembler) Stats() StreamStats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	stats := a.stats
+	stats.PendingFrames = len(a.pending)
+	return stats
+}
@@ -0,0 +1,79 @@
+package goomo
+
+//lc_result.go contains the errors which are returned by ExecuteCommand
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrNotConnected   = errors.New("not connected to Loomo")
+	ErrCommandTimeout = errors.New("command timed out")
+	// ErrStopped is returned for commands after the context of Start is done
+	ErrStopped = errors.New("communicator stopped")
+)
+
+// Steps of sending a command, which are reported in a CommandError
+const (
+	OpQueue  = "queueing"
+	OpEncode = "encoding"
+	OpStream = "opening stream for"
+	OpWrite  = "writing"
+)
+
+// CommandError tells which step of sending a command to the Loomo failed
+type CommandError struct {
+	Tag CommandTag
+	Op  string
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s %v: %v", e.Op, e.Tag, e.Err)
+}
+
+type commandRequest struct {
+	cmd    Command
+	result chan error
+}
+
+// commandCause returns the reason of a CommandError or err itself
+func commandCause(err error) error {
+	if cerr, ok := err.(*CommandError); ok {
+		return cerr.Err
+	}
+	return err
+}
+
+// commandStatus maps the result of ExecuteCommand to a HTTP status code
+func commandStatus(err error) int {
+	cerr, ok := err.(*CommandError)
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case !ok:
+		return http.StatusInternalServerError
+	case cerr.Err == ErrNotConnected || cerr.Err == ErrStopped:
+		return http.StatusServiceUnavailable
+	case cerr.Err == ErrCommandTimeout:
+		return http.StatusGatewayTimeout
+	case cerr.Op == OpEncode:
+		return http.StatusBadRequest
+	case cerr.Op == OpWrite:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeCommandError answers a request with the status of err, it returns false if err is nil
+func writeCommandError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	logger.Error(err)
+	http.Error(w, err.Error(), commandStatus(err))
+	return true
+}
@@ -0,0 +1,245 @@
+package goomo
+
+//lc_scheduler.go decides in which order the commands are written to the Loomo
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCommandInterval = 10 * time.Millisecond
+	maxQueuedCommands      = 64
+)
+
+var ErrQueueFull = errors.New("command queue is full")
+
+// QueueStats describes the command queue in front of the TCP connection
+type QueueStats struct {
+	Urgent    int    `json:"urgent"`
+	Queued    int    `json:"queued"`
+	MaxQueued int    `json:"maxQueued"`
+	Sent      uint64 `json:"sent"`
+	Coalesced uint64 `json:"coalesced"`
+	Rejected  uint64 `json:"rejected"`
+	Failed    uint64 `json:"failed"`
+}
+
+// commandScheduler sends stop commands first and without rate limit, a repeated stop replaces the pending one.
+// Of all other commands only the latest CLVLCommand and CAVLCommand are kept, at their original position in the queue.
+type commandScheduler struct {
+	lock     sync.Mutex
+	urgent   []*commandRequest
+	queue    []*commandRequest
+	closed   bool
+	wake     chan bool
+	interval time.Duration
+	last     time.Time
+	stats    QueueStats
+	metrics  *moduleMetrics
+}
+
+func newCommandScheduler(interval time.Duration) *commandScheduler {
+	return &commandScheduler{
+		wake:     make(chan bool, 1),
+		interval: interval,
+		metrics:  processMetrics,
+	}
+}
+
+// isStop reports whether cmd sets a velocity to 0
+func isStop(cmd Command) bool {
+	switch c := cmd.(type) {
+	case *CLVLCommand:
+		return c.Lv == 0
+	case *CAVLCommand:
+		return c.Av == 0
+	}
+	return false
+}
+
+func isVelocity(cmd Command) bool {
+	tag := cmd.Tag()
+	return tag == CLVL || tag == CAVL
+}
+
+func (req *commandRequest) resolve(err error) {
+	if req.result != nil {
+		req.result <- err
+	}
+}
+
+// push queues req, a superseded velocity command is resolved without error
+func (s *commandScheduler) push(req *commandRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStopped
+	}
+
+	if isStop(req.cmd) {
+		// a pending velocity must not be sent after the stop
+		s.remove(req.cmd.Tag())
+		// repeated stops are coalesced, so the urgent queue stays bounded
+		for i, queued := range s.urgent {
+			if queued.cmd.Tag() == req.cmd.Tag() {
+				s.urgent[i] = req
+				s.stats.Coalesced++
+				s.metrics.lcCoalesced.With().Inc()
+				queued.resolve(nil)
+				return nil
+			}
+		}
+		s.urgent = append(s.urgent, req)
+		s.observe()
+		s.notify()
+		return nil
+	}
+	if isVelocity(req.cmd) {
+		for i, queued := range s.queue {
+			if queued.cmd.Tag() == req.cmd.Tag() {
+				s.queue[i] = req
+				s.stats.Coalesced++
+				s.metrics.lcCoalesced.With().Inc()
+				queued.resolve(nil)
+				return nil
+			}
+		}
+	}
+	if len(s.queue) >= maxQueuedCommands {
+		s.stats.Rejected++
+		s.metrics.lcRejected.With().Inc()
+		return ErrQueueFull
+	}
+	s.queue = append(s.queue, req)
+	if len(s.queue) > s.stats.MaxQueued {
+		s.stats.MaxQueued = len(s.queue)
+	}
+	s.observe()
+	s.notify()
+	return nil
+}
+
+// remove resolves and drops the queued command with tag
+func (s *commandScheduler) remove(tag CommandTag) {
+	for i, queued := range s.queue {
+		if queued.cmd.Tag() == tag {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.stats.Coalesced++
+			s.metrics.lcCoalesced.With().Inc()
+			queued.resolve(nil)
+			return
+		}
+	}
+}
+
+// cancel drops req if it was not taken by the writer yet
+func (s *commandScheduler) cancel(req *commandRequest) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, queue := range []*[]*commandRequest{&s.urgent, &s.queue} {
+		for i, queued := range *queue {
+			if queued == req {
+				*queue = append((*queue)[:i], (*queue)[i+1:]...)
+				s.observe()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// observe exports the queue lengths, it has to be called with lock held
+func (s *commandScheduler) observe() {
+	s.metrics.lcQueueLength.With("urgent").Set(float64(len(s.urgent)))
+	s.metrics.lcQueueLength.With("normal").Set(float64(len(s.queue)))
+}
+
+func (s *commandScheduler) notify() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// close rejects new commands, the queued ones are still written
+func (s *commandScheduler) close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	s.notify()
+}
+
+// shutdown rejects new commands and drops the queued ones, final is written instead
+func (s *commandScheduler) shutdown(final []*commandRequest) {
+	s.lock.Lock()
+	for _, req := range append(s.urgent, s.queue...) {
+		req.resolve(&CommandError{req.cmd.Tag(), OpQueue, ErrStopped})
+	}
+	s.urgent = final
+	s.queue = nil
+	s.closed = true
+	s.observe()
+	s.lock.Unlock()
+	s.notify()
+}
+
+// next blocks until a command may be written, it returns false after close once the queue is empty
+func (s *commandScheduler) next() (*commandRequest, bool) {
+	for {
+		s.lock.Lock()
+		var req *commandRequest
+		var wait time.Duration
+		switch {
+		case len(s.urgent) > 0:
+			req, s.urgent = s.urgent[0], s.urgent[1:]
+		case len(s.queue) > 0:
+			wait = s.interval - time.Since(s.last)
+			if wait <= 0 {
+				req, s.queue = s.queue[0], s.queue[1:]
+			}
+		case s.closed:
+			s.lock.Unlock()
+			return nil, false
+		}
+		if req != nil {
+			s.last = time.Now()
+			s.observe()
+			s.lock.Unlock()
+			return req, true
+		}
+		s.lock.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-s.wake:
+				timer.Stop()
+			}
+		} else {
+			<-s.wake
+		}
+	}
+}
+
+func (s *commandScheduler) sent(err error) {
+	s.lock.Lock()
+	if err != nil {
+		s.stats.Failed++
+	} else {
+		s.stats.Sent++
+	}
+	s.lock.Unlock()
+}
+
+func (s *commandScheduler) Stats() QueueStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := s.stats
+	stats.Urgent = len(s.urgent)
+	stats.Queued = len(s.queue)
+	return stats
+}
@@ -0,0 +1,94 @@
+package goomo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SensorRegistry keeps the handler of every stream of a Loomo together with the UDP port it is received on
+type SensorRegistry struct {
+	lc       *LoomoCommunicator
+	lock     sync.Mutex
+	handlers map[string]SensorHandler
+	ports    map[string]string
+}
+
+func NewSensorRegistry(lc *LoomoCommunicator) *SensorRegistry {
+	return &SensorRegistry{
+		lc:       lc,
+		handlers: make(map[string]SensorHandler),
+		ports:    make(map[string]string),
+	}
+}
+
+// Register receives the stream of handler on port, every stream needs its own port
+func (r *SensorRegistry) Register(handler SensorHandler, port string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for stream, p := range r.ports {
+		if p == port && stream != handler.Stream() {
+			return fmt.Errorf("port %s is already used by stream %s", port, stream)
+		}
+	}
+	r.handlers[handler.Stream()] = handler
+	r.ports[handler.Stream()] = port
+	r.lc.RegisterHandler("S"+handler.Stream(), handler)
+	return nil
+}
+
+// Port returns the UDP port of stream
+func (r *SensorRegistry) Port(stream string) (string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	port, ok := r.ports[stream]
+	return port, ok
+}
+
+// Streams returns the names of all registered streams in lexical order
+func (r *SensorRegistry) Streams() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	streams := make([]string, 0, len(r.ports))
+	for stream := range r.ports {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	return streams
+}
+
+// IsActive reports whether the stream was started and not stopped since
+func (r *SensorRegistry) IsActive(stream string) bool {
+	port, ok := r.Port(stream)
+	if !ok {
+		return false
+	}
+	portInt, err := strconv.Atoi(strings.TrimPrefix(port, ":"))
+	if err != nil {
+		return false
+	}
+	r.lc.streamsLock.Lock()
+	defer r.lc.streamsLock.Unlock()
+	_, ok = r.lc.Streams[portInt]
+	return ok
+}
+
+// Start requests the stream from the Loomo
+func (r *SensorRegistry) Start(stream string) error {
+	port, ok := r.Port(stream)
+	if !ok {
+		return fmt.Errorf("unknown stream %q", stream)
+	}
+	return r.lc.ExecuteCommand(&CSSTCommand{port, stream})
+}
+
+// Stop ends the stream
+func (r *SensorRegistry) Stop(stream string) error {
+	port, ok := r.Port(stream)
+	if !ok {
+		return fmt.Errorf("unknown stream %q", stream)
+	}
+	return r.lc.ExecuteCommand(&CESTCommand{port, stream})
+}
@@ -0,0 +1,218 @@
+package goomo
+
+//lc_sensors.go decodes the non-camera sensor streams of the Loomo.
+//All values are big endian like the commands, angles are in rad and distances in cm.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Stream names which are used in CSSTCommand and CESTCommand
+const (
+	CameraStream     = "CAM"
+	IMUStream        = "IMU"
+	OdometryStream   = "ODO"
+	DepthStream      = "DEP"
+	UltrasonicStream = "ULS"
+)
+
+// IMUData is the orientation of the Loomo base and its acceleration in m/s²
+type IMUData struct {
+	Timestamp uint64
+	Pitch     float32
+	Roll      float32
+	Yaw       float32
+	AccX      float32
+	AccY      float32
+	AccZ      float32
+}
+
+// OdometryData is the pose of the Loomo calculated from its wheels and its current velocities
+type OdometryData struct {
+	Timestamp uint64
+	X         float32
+	Y         float32
+	Theta     float32
+	Lv        float32
+	Av        float32
+}
+
+// UltrasonicData is the distance to the nearest obstacle in front of the Loomo
+type UltrasonicData struct {
+	Timestamp uint64
+	Distance  float32
+}
+
+// DepthData is the image of the depth camera, row by row in mm
+type DepthData struct {
+	Timestamp uint64
+	Width     int
+	Height    int
+	Depth     []uint16
+}
+
+func putFloat32s(b []byte, values ...float32) {
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[i*4:], math.Float32bits(v))
+	}
+}
+
+func floatsOf(data []byte, n int, stream string) ([]float32, error) {
+	if len(data) != n*4 {
+		return nil, fmt.Errorf("%s frame has %d bytes, want %d", stream, len(data), n*4)
+	}
+	values := make([]float32, n)
+	for i := range values {
+		values[i] = parseFloat32(data[i*4:])
+	}
+	return values, nil
+}
+
+func (d *IMUData) Bytes() []byte {
+	b := make([]byte, 24)
+	putFloat32s(b, d.Pitch, d.Roll, d.Yaw, d.AccX, d.AccY, d.AccZ)
+	return b
+}
+
+func DecodeIMU(d *LoomoData) (*IMUData, error) {
+	v, err := floatsOf(d.data, 6, IMUStream)
+	if err != nil {
+		return nil, err
+	}
+	return &IMUData{d.timestamp, v[0], v[1], v[2], v[3], v[4], v[5]}, nil
+}
+
+func (d *OdometryData) Bytes() []byte {
+	b := make([]byte, 20)
+	putFloat32s(b, d.X, d.Y, d.Theta, d.Lv, d.Av)
+	return b
+}
+
+func DecodeOdometry(d *LoomoData) (*OdometryData, error) {
+	v, err := floatsOf(d.data, 5, OdometryStream)
+	if err != nil {
+		return nil, err
+	}
+	return &OdometryData{d.timestamp, v[0], v[1], v[2], v[3], v[4]}, nil
+}
+
+func (d *UltrasonicData) Bytes() []byte {
+	b := make([]byte, 4)
+	putFloat32s(b, d.Distance)
+	return b
+}
+
+func DecodeUltrasonic(d *LoomoData) (*UltrasonicData, error) {
+	v, err := floatsOf(d.data, 1, UltrasonicStream)
+	if err != nil {
+		return nil, err
+	}
+	return &UltrasonicData{d.timestamp, v[0]}, nil
+}
+
+// Bytes returns width and height as uint16 followed by the depth values
+func (d *DepthData) Bytes() []byte {
+	b := make([]byte, 4+2*len(d.Depth))
+	binary.BigEndian.PutUint16(b[0:2], uint16(d.Width))
+	binary.BigEndian.PutUint16(b[2:4], uint16(d.Height))
+	for i, v := range d.Depth {
+		binary.BigEndian.PutUint16(b[4+2*i:], v)
+	}
+	return b
+}
+
+func DecodeDepth(d *LoomoData) (*DepthData, error) {
+	if len(d.data) < 4 {
+		return nil, fmt.Errorf("%s frame has %d bytes, which is shorter than its header", DepthStream, len(d.data))
+	}
+	width := int(binary.BigEndian.Uint16(d.data[0:2]))
+	height := int(binary.BigEndian.Uint16(d.data[2:4]))
+	if len(d.data) != 4+2*width*height {
+		return nil, fmt.Errorf("%s frame of %dx%d has %d bytes, want %d", DepthStream, width, height, len(d.data), 4+2*width*height)
+	}
+	depth := make([]uint16, width*height)
+	for i := range depth {
+		depth[i] = binary.BigEndian.Uint16(d.data[4+2*i:])
+	}
+	return &DepthData{d.timestamp, width, height, depth}, nil
+}
+
+// SensorHandler is a StreamDataHandler for one of the named streams, e.g. IMUStream
+type SensorHandler interface {
+	StreamDataHandler
+	Stream() string
+}
+
+// The typed handlers publish every decoded frame on Outbound, frames are dropped if nobody is receiving
+
+// handleSensorData sends every frame of stream decoded by decode to the channel outbound without blocking
+func handleSensorData(stream *SensorStream, outbound interface{}, decode func(*LoomoData) (interface{}, error)) {
+	out := reflect.ValueOf(outbound)
+	for data := range stream.Data {
+		decoded, err := decode(data)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		out.TrySend(reflect.ValueOf(decoded))
+	}
+}
+
+type IMUHandler struct {
+	Outbound chan *IMUData
+}
+
+func (h *IMUHandler) Stream() string {
+	return IMUStream
+}
+
+func (h *IMUHandler) HandleStream(stream *SensorStream, _ chan Command) {
+	handleSensorData(stream, h.Outbound, func(data *LoomoData) (interface{}, error) {
+		return DecodeIMU(data)
+	})
+}
+
+type OdometryHandler struct {
+	Outbound chan *OdometryData
+}
+
+func (h *OdometryHandler) Stream() string {
+	return OdometryStream
+}
+
+func (h *OdometryHandler) HandleStream(stream *SensorStream, _ chan Command) {
+	handleSensorData(stream, h.Outbound, func(data *LoomoData) (interface{}, error) {
+		return DecodeOdometry(data)
+	})
+}
+
+type UltrasonicHandler struct {
+	Outbound chan *UltrasonicData
+}
+
+func (h *UltrasonicHandler) Stream() string {
+	return UltrasonicStream
+}
+
+func (h *UltrasonicHandler) HandleStream(stream *SensorStream, _ chan Command) {
+	handleSensorData(stream, h.Outbound, func(data *LoomoData) (interface{}, error) {
+		return DecodeUltrasonic(data)
+	})
+}
+
+type DepthHandler struct {
+	Outbound chan *DepthData
+}
+
+func (h *DepthHandler) Stream() string {
+	return DepthStream
+}
+
+func (h *DepthHandler) HandleStream(stream *SensorStream, _ chan Command) {
+	handleSensorData(stream, h.Outbound, func(data *LoomoData) (interface{}, error) {
+		return DecodeDepth(data)
+	})
+}
@@ -0,0 +1,119 @@
+package goomo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// FrameSource delivers JPG encoded camera frames to the LoomoSimulator
+type FrameSource interface {
+	NextFrame() (JPG, error)
+	Close() error
+}
+
+// NewFrameSource opens a JPGDirSource for directories and a VideoFileSource for everything else
+func NewFrameSource(path string) (FrameSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening frame source '%s': %v", path, err)
+	}
+	if info.IsDir() {
+		return NewJPGDirSource(path)
+	}
+	return NewVideoFileSource(path)
+}
+
+// JPGDirSource loops over all JPG files of a directory in lexical order
+type JPGDirSource struct {
+	files []string
+	next  int
+	lock  sync.Mutex
+}
+
+func NewJPGDirSource(dir string) (*JPGDirSource, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading frame directory '%s': %v", dir, err)
+	}
+	files := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if !info.IsDir() && (ext == ".jpg" || ext == ".jpeg") {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no JPG files in '%s'", dir)
+	}
+	sort.Strings(files)
+	return &JPGDirSource{files: files}, nil
+}
+
+func (j *JPGDirSource) NextFrame() (JPG, error) {
+	j.lock.Lock()
+	file := j.files[j.next]
+	j.next = (j.next + 1) % len(j.files)
+	j.lock.Unlock()
+	return ioutil.ReadFile(file)
+}
+
+func (j *JPGDirSource) Close() error {
+	return nil
+}
+
+// VideoFileSource loops over the frames of a video file and encodes them as JPG
+type VideoFileSource struct {
+	Filename string
+	vc       *gocv.VideoCapture
+	mat      gocv.Mat
+	lock     sync.Mutex
+}
+
+func NewVideoFileSource(filename string) (*VideoFileSource, error) {
+	vc, err := gocv.VideoCaptureFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening video '%s': %v", filename, err)
+	}
+	return &VideoFileSource{
+		Filename: filename,
+		vc:       vc,
+		mat:      gocv.NewMat(),
+	}, nil
+}
+
+func (v *VideoFileSource) NextFrame() (JPG, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !v.vc.Read(&v.mat) || v.mat.Empty() {
+		// start over at the end of the video
+		err := v.vc.Close()
+		if err != nil {
+			logger.Error(err)
+		}
+		v.vc, err = gocv.VideoCaptureFile(v.Filename)
+		if err != nil {
+			return nil, fmt.Errorf("reopening video '%s': %v", v.Filename, err)
+		}
+		if !v.vc.Read(&v.mat) || v.mat.Empty() {
+			return nil, fmt.Errorf("video '%s' has no frames", v.Filename)
+		}
+	}
+	return gocv.IMEncode(gocv.JPEGFileExt, v.mat)
+}
+
+func (v *VideoFileSource) Close() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	err := v.mat.Close()
+	if err != nil {
+		logger.Error(err)
+	}
+	return v.vc.Close()
+}
@@ -0,0 +1,369 @@
+package goomo
+
+//sim_loomo.go is a fake Loomo which speaks the same wire protocol as the Android app
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoomoSimulator imitates a Loomo on the network: it announces its command port via UDP broadcast,
+// accepts the TCP connection of a LoomoCommunicator, decodes the commands and streams camera frames
+// from a FrameSource back over UDP.
+// The other sensor streams are generated from a pose which is integrated from the commanded velocities.
+type LoomoSimulator struct {
+	// BCAddr is the address the command port is announced to
+	BCAddr string
+	// Port is the TCP port on which commands are accepted, ":0" picks a free one
+	Port string
+	// Serial is announced after the port if it is set
+	Serial           string
+	AnnounceInterval time.Duration
+	FrameInterval    time.Duration
+	Frames           FrameSource
+	// ObstacleDistance in cm is reported by the ultrasonic and depth streams
+	ObstacleDistance float32
+	// Received gets every decoded command if set, commands are dropped if nobody listens
+	Received chan Command
+
+	lock     sync.Mutex
+	lv       float32
+	av       float32
+	x        float32
+	y        float32
+	theta    float32
+	moved    time.Time
+	head     CMHDCommand
+	streams  map[int]chan bool
+	listener net.Listener
+	done     chan bool
+	// Close may be called more than once
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewLoomoSimulator creates a simulator with the same ports the real Loomo uses
+func NewLoomoSimulator(frames FrameSource) *LoomoSimulator {
+	return &LoomoSimulator{
+		BCAddr:           "255.255.255.255:1336",
+		Port:             ":1337",
+		AnnounceInterval: time.Second,
+		FrameInterval:    time.Second / 30,
+		Frames:           frames,
+		ObstacleDistance: 200,
+		moved:            time.Now(),
+		streams:          make(map[int]chan bool),
+		done:             make(chan bool),
+	}
+}
+
+func (s *LoomoSimulator) Start() error {
+	var err error
+	s.listener, err = net.Listen("tcp", s.Port)
+	if err != nil {
+		return fmt.Errorf("listening for commands on %s: %v", s.Port, err)
+	}
+	logger.Debugf("LoomoSimulator listening on %v", s.listener.Addr())
+
+	go s.announce()
+	go s.accept()
+	return nil
+}
+
+// Addr returns the address on which the simulator accepts commands
+func (s *LoomoSimulator) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Velocities returns the linear and angular velocity which were last commanded
+func (s *LoomoSimulator) Velocities() (lv, av float32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lv, s.av
+}
+
+// Position returns the pose in cm and rad, which is integrated from the velocities since the last CSPS
+func (s *LoomoSimulator) Position() (x, y, theta float32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.advance()
+	return s.x, s.y, s.theta
+}
+
+// advance has to be called with lock held before the velocities change
+func (s *LoomoSimulator) advance() {
+	now := time.Now()
+	dt := float32(now.Sub(s.moved).Seconds())
+	s.moved = now
+	s.theta += s.av * dt
+	s.x += 100 * s.lv * dt * float32(math.Cos(float64(s.theta)))
+	s.y += 100 * s.lv * dt * float32(math.Sin(float64(s.theta)))
+}
+
+// Head returns the head command which was received last
+func (s *LoomoSimulator) Head() CMHDCommand {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.head
+}
+
+// Close stops the simulator, it may be called before Start and more than once
+func (s *LoomoSimulator) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+func (s *LoomoSimulator) close() error {
+	close(s.done)
+	s.lock.Lock()
+	for port, stop := range s.streams {
+		close(stop)
+		delete(s.streams, port)
+	}
+	s.lock.Unlock()
+	if s.Frames != nil {
+		s.Frames.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *LoomoSimulator) announce() {
+	conn, err := net.Dial("udp", s.BCAddr)
+	if err != nil {
+		logger.Errorf("dialling broadcast address %s: %v", s.BCAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	port := strconv.Itoa(s.listener.Addr().(*net.TCPAddr).Port)
+	announcement := port + "\n"
+	if s.Serial != "" {
+		announcement = port + " " + s.Serial + "\n"
+	}
+	ticker := time.NewTicker(s.AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		_, err := conn.Write([]byte(announcement))
+		if err != nil {
+			logger.Debugf("announcing port %s: %v", port, err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *LoomoSimulator) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				logger.Errorf("accepting command connection: %v", err)
+			}
+			return
+		}
+		logger.Debugf("LoomoSimulator accepted connection from %v", conn.RemoteAddr())
+		go s.serve(conn)
+	}
+}
+
+func (s *LoomoSimulator) serve(conn net.Conn) {
+	defer conn.Close()
+	peer := conn.RemoteAddr().(*net.TCPAddr).IP
+
+	reader := NewCommandReader(conn)
+	for {
+		cmd, err := reader.ReadCommand()
+		if err != nil {
+			if _, ok := err.(*CommandParseError); ok {
+				logger.Error(err)
+				continue
+			}
+			if err != io.EOF {
+				logger.Errorf("reading command: %v", err)
+			}
+			return
+		}
+		s.handle(cmd, peer)
+	}
+}
+
+func (s *LoomoSimulator) handle(cmd Command, peer net.IP) {
+	switch c := cmd.(type) {
+	case *CSSTCommand:
+		port, err := strconv.Atoi(strings.TrimPrefix(c.Port, ":"))
+		if err != nil {
+			logger.Errorf("invalid port %q: %v", c.Port, err)
+			return
+		}
+		switch c.Stream {
+		case CameraStream, IMUStream, OdometryStream, UltrasonicStream, DepthStream:
+		default:
+			logger.Errorf("LoomoSimulator cannot stream %q", c.Stream)
+			return
+		}
+		s.startStream(&net.UDPAddr{IP: peer, Port: port}, "S"+c.Stream)
+	case *CESTCommand:
+		port, err := strconv.Atoi(strings.TrimPrefix(c.Port, ":"))
+		if err != nil {
+			logger.Errorf("invalid port %q: %v", c.Port, err)
+			return
+		}
+		s.stopStream(port)
+	case *CLVLCommand:
+		s.lock.Lock()
+		s.advance()
+		s.lv = c.Lv
+		s.lock.Unlock()
+	case *CAVLCommand:
+		s.lock.Lock()
+		s.advance()
+		s.av = c.Av
+		s.lock.Unlock()
+	case *CSPSCommand:
+		s.lock.Lock()
+		s.advance()
+		s.x, s.y, s.theta = c.X, c.Y, 0
+		s.lock.Unlock()
+	case *CMHDCommand:
+		s.lock.Lock()
+		s.head = *c
+		s.lock.Unlock()
+	}
+
+	if s.Received != nil {
+		select {
+		case s.Received <- cmd:
+		default:
+		}
+	}
+}
+
+func (s *LoomoSimulator) startStream(addr *net.UDPAddr, tag string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.streams[addr.Port]; ok {
+		return
+	}
+	stop := make(chan bool)
+	s.streams[addr.Port] = stop
+	go s.stream(addr, tag, stop)
+}
+
+func (s *LoomoSimulator) stopStream(port int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if stop, ok := s.streams[port]; ok {
+		close(stop)
+		delete(s.streams, port)
+	}
+}
+
+func (s *LoomoSimulator) stream(addr *net.UDPAddr, tag string, stop chan bool) {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		logger.Errorf("dialling UDP %v: %v", addr, err)
+		return
+	}
+	defer conn.Close()
+	logger.Debugf("LoomoSimulator streaming %s to %v", tag, addr)
+
+	seq := uint32(0)
+	ticker := time.NewTicker(s.FrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			logger.Debugf("LoomoSimulator stopped streaming %s to %v", tag, addr)
+			return
+		case <-ticker.C:
+		}
+
+		frame, err := s.sensorFrame(tag)
+		if err != nil {
+			logger.Errorf("reading frame of %s: %v", tag, err)
+			continue
+		}
+		seq, err = writeSensorPackets(conn, tag, seq, uint64(time.Now().UnixNano()/int64(time.Millisecond)), frame)
+		if err != nil {
+			logger.Debugf("sending frame to %v: %v", addr, err)
+		}
+	}
+}
+
+func (s *LoomoSimulator) sensorFrame(tag string) ([]byte, error) {
+	if tag == "S"+CameraStream {
+		if s.Frames == nil {
+			return nil, fmt.Errorf("no frame source")
+		}
+		return s.Frames.NextFrame()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.advance()
+	switch tag {
+	case "S" + IMUStream:
+		return (&IMUData{Yaw: s.theta, AccZ: 9.81}).Bytes(), nil
+	case "S" + OdometryStream:
+		return (&OdometryData{X: s.x, Y: s.y, Theta: s.theta, Lv: s.lv, Av: s.av}).Bytes(), nil
+	case "S" + UltrasonicStream:
+		return (&UltrasonicData{Distance: s.ObstacleDistance}).Bytes(), nil
+	case "S" + DepthStream:
+		depth := &DepthData{Width: 80, Height: 60, Depth: make([]uint16, 80*60)}
+		for i := range depth.Depth {
+			depth.Depth[i] = uint16(s.ObstacleDistance * 10)
+		}
+		return depth.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown stream %s", tag)
+	}
+}
+
+// writeSensorPackets splits data into packets with the 24 byte header parsed by sensorHeaderHandler
+// and returns the sequence number for the next frame
+func writeSensorPackets(w io.Writer, tag string, seq uint32, tval uint64, data []byte) (uint32, error) {
+	chunkSize := maxPacketSize - headerSize
+	chunks := (len(data) + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	start := seq
+	end := seq + uint32(chunks) - 1
+
+	packet := make([]byte, maxPacketSize)
+	for i := 0; i < chunks; i++ {
+		from := i * chunkSize
+		to := from + chunkSize
+		if to > len(data) {
+			to = len(data)
+		}
+		copy(packet[0:4], tag)
+		binary.BigEndian.PutUint32(packet[4:8], start+uint32(i))
+		binary.BigEndian.PutUint64(packet[8:16], tval)
+		binary.BigEndian.PutUint32(packet[16:20], start)
+		binary.BigEndian.PutUint32(packet[20:24], end)
+		n := copy(packet[headerSize:], data[from:to])
+		_, err := w.Write(packet[:headerSize+n])
+		if err != nil {
+			return end + 1, err
+		}
+	}
+	return end + 1, nil
+}
@@ -0,0 +1,63 @@
+package goomo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSimulatorReceivesVelocities(t *testing.T) {
+	sim := NewLoomoSimulator(nil)
+	sim.Port = "127.0.0.1:0"
+	sim.BCAddr = "127.0.0.1:1336"
+	sim.Received = make(chan Command, 16)
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	lc := NewLoomoCommunicator()
+	lc.Addr = sim.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		lc.Wait()
+	}()
+	lc.Start(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for !lc.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("not connected to the simulator")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := lc.ExecuteCommand(&CLVLCommand{Lv: 0.25}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lc.ExecuteCommand(&CAVLCommand{Av: -0.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lv *CLVLCommand
+	var av *CAVLCommand
+	for lv == nil || av == nil {
+		select {
+		case cmd := <-sim.Received:
+			switch c := cmd.(type) {
+			case *CLVLCommand:
+				lv = c
+			case *CAVLCommand:
+				av = c
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the simulator did not receive CLVL and CAVL")
+		}
+	}
+	if lv.Lv != 0.25 || av.Av != -0.5 {
+		t.Errorf("received lv %v and av %v, want 0.25 and -0.5", lv.Lv, av.Av)
+	}
+	if gotLv, gotAv := sim.Velocities(); gotLv != 0.25 || gotAv != -0.5 {
+		t.Errorf("simulator drives with lv %v and av %v, want 0.25 and -0.5", gotLv, gotAv)
+	}
+}
@@ -1,9 +1,11 @@
 package goomo
 
 import (
+	"fmt"
 	"gocv.io/x/gocv"
 	"net"
 	"sync"
+	"time"
 )
 
 // JPG is used for byte slices which are only to be interpreted as an JPG formatted image
@@ -22,10 +24,39 @@ const (
 	CHMD
 )
 
+var commandTagNames = map[CommandTag]string{
+	CSST: "CSST",
+	CEST: "CEST",
+	CSPS: "CSPS",
+	CLVL: "CLVL",
+	CAVL: "CAVL",
+	CHMD: "CHMD",
+}
+
+// String returns the four letters by which the tag is sent over the wire
+func (t CommandTag) String() string {
+	if name, ok := commandTagNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("CommandTag(%d)", t)
+}
+
+// ParseCommandTag returns the CommandTag for its four letter wire format
+func ParseCommandTag(name string) (CommandTag, bool) {
+	for tag, n := range commandTagNames {
+		if n == name {
+			return tag, true
+		}
+	}
+	return 0, false
+}
+
 const (
 	maxPacketSize = 8192
 	headerSize    = 24
 	workerThreads = 1
+	// commands are only a few bytes, everything larger is a broken stream
+	maxCommandSize = 1024
 )
 
 type Command interface {
@@ -37,13 +68,44 @@ type Command interface {
 
 // LoomoCommunicator specifies the Main type through which communication with a Loomo should happen
 type LoomoCommunicator struct {
-	BCport    string
-	loomoAddr string
-	conn      *net.TCPConn
-	done      chan bool
-	Cmds      chan Command
-	Streams   map[int]*SensorStream
-	handlers  map[string]StreamDataHandler
+	BCport string
+	// Addr is the fixed host:port of the Loomo, if it is set no broadcast is awaited
+	Addr string
+	// Target selects the Loomo by serial, IP address or host:port if several announce themselves
+	Target string
+	// DiscoveryTimeout is the maximum time to wait for a broadcast, 0 waits forever
+	DiscoveryTimeout time.Duration
+	// WorkerThreads is the number of goroutines which reassemble the packets of each stream, at least 1
+	WorkerThreads int
+	// FrameTimeout (at least 50ms) is the time after which incomplete frames are dropped,
+	// at most MaxPendingFrames (at least 1) incomplete frames are kept per stream
+	FrameTimeout     time.Duration
+	MaxPendingFrames int
+	// CommandTimeout limits how long ExecuteCommand waits for a command to be written
+	CommandTimeout time.Duration
+	// CommandInterval is the minimal time between two commands, except for stops
+	CommandInterval time.Duration
+	// MinBackoff and MaxBackoff bound the waiting time between two connection attempts
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+	loomoAddr      string
+	conn           *net.TCPConn
+	connLock       sync.Mutex
+	status         ConnectionEvent
+	stateListeners map[string]chan ConnectionEvent
+	cmdListeners   map[string]chan Command
+	dataListeners  map[string]chan StreamData
+	lost           chan bool
+	done           chan bool
+	Cmds           chan Command
+	scheduler      *commandScheduler
+	Streams        map[int]*SensorStream
+	streamsLock    sync.Mutex
+	handlers       map[string]StreamDataHandler
+	// intakeListeners receive the commands before they are queued
+	intakeListeners map[string]IntakeListener
+	// metrics count the commands and frames, a Goomo sets its own
+	metrics *moduleMetrics
 }
 
 type StreamDataHandler interface {
@@ -58,16 +120,36 @@ func (s StreamDataHandlerFunc) HandleStream(stream *SensorStream, cmds chan Comm
 
 // SensorStream contains all the data and endpoints which are needed when receiving sensoric data from Loomo
 type SensorStream struct {
-	Data       chan *LoomoData
-	unfinished map[uint64]map[uint32][]byte
-	packets    chan []byte
-	Conn       *net.UDPConn
+	Data      chan *LoomoData
+	assembler *frameAssembler
+	packets   chan []byte
+	Conn      *net.UDPConn
+	// cmd is sent again after reconnecting to the Loomo
+	cmd *CSSTCommand
 }
 
 // NewLoomoCommunicator creates a new basic LoomoCommunicator with standard parameters
 func NewLoomoCommunicator() *LoomoCommunicator {
-	lc := LoomoCommunicator{BCport: ":1336"}
+	lc := LoomoCommunicator{
+		BCport:           ":1336",
+		DiscoveryTimeout: defaultDiscoveryTimeout,
+		WorkerThreads:    workerThreads,
+		FrameTimeout:     defaultFrameTimeout,
+		MaxPendingFrames: defaultMaxPendingFrames,
+		CommandTimeout:   2 * time.Second,
+		CommandInterval:  defaultCommandInterval,
+		MinBackoff:       500 * time.Millisecond,
+		MaxBackoff:       30 * time.Second,
+	}
 	lc.Cmds = make(chan Command)
+	lc.scheduler = newCommandScheduler(defaultCommandInterval)
+	lc.done = make(chan bool)
+	lc.lost = make(chan bool, 1)
+	lc.stateListeners = make(map[string]chan ConnectionEvent)
+	lc.cmdListeners = make(map[string]chan Command)
+	lc.intakeListeners = make(map[string]IntakeListener)
+	lc.dataListeners = make(map[string]chan StreamData)
+	lc.status = ConnectionEvent{State: Disconnected, Time: time.Now()}
 	lc.Streams = make(map[int]*SensorStream)
 	lc.handlers = make(map[string]StreamDataHandler)
 	return &lc
@@ -75,9 +157,8 @@ func NewLoomoCommunicator() *LoomoCommunicator {
 
 func NewSensorStream() *SensorStream {
 	s := SensorStream{
-		Data:       make(chan *LoomoData),
-		unfinished: make(map[uint64]map[uint32][]byte),
-		packets:    make(chan []byte),
+		Data:    make(chan *LoomoData),
+		packets: make(chan []byte),
 	}
 	return &s
 }
@@ -88,25 +169,34 @@ type ManagedMat struct {
 	wg        *sync.WaitGroup
 	lock      *sync.Mutex
 	timestamp uint64
-	functions []FinishFunction
+	functions []layeredFunction
+	screen    *DebugScreen
 }
 
 type FinishFunctionStack struct {
 	head *FinishFunctionNode
 }
 
-func (m *ManagedMat) put(function FinishFunction) {
+// put adds a drawing to the layer, see DebugScreen
+func (m *ManagedMat) put(layer string, function FinishFunction) {
 	m.lock.Lock()
-	m.functions = append(m.functions, function)
+	m.functions = append(m.functions, layeredFunction{layer: layer, draw: function})
 	m.lock.Unlock()
 }
 
+// ForEach draws all layers on the mat
 func (s *ManagedMat) ForEach() {
 	for i := len(s.functions) - 1; i >= 0; i-- {
-		s.functions[i](s.mat)
+		s.functions[i].draw(s.mat)
 	}
 }
 
+func (m *ManagedMat) layers() []layeredFunction {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]layeredFunction(nil), m.functions...)
+}
+
 type FinishFunctionNode struct {
 	next   *FinishFunctionNode
 	finish FinishFunction
@@ -114,10 +204,15 @@ type FinishFunctionNode struct {
 
 type FinishFunction func(mat *gocv.Mat)
 
+type layeredFunction struct {
+	layer string
+	draw  FinishFunction
+}
+
 func (mm *ManagedMat) Init(mat *gocv.Mat) *ManagedMat {
 	mm.mat = mat
 	mm.wg = &sync.WaitGroup{}
-	mm.functions = make([]FinishFunction, 0, 10)
+	mm.functions = make([]layeredFunction, 0, 10)
 	return mm
 }
 
@@ -139,12 +234,8 @@ func (mm *ManagedMat) Done() *ManagedMat {
 
 func (mm *ManagedMat) Finish() {
 	mm.wg.Wait()
-	if DebugScreen {
-		mm.ForEach()
-		if DebugScreen {
-			window.IMShow(*mm.mat)
-			window.WaitKey(1)
-		}
+	if mm.screen != nil {
+		mm.screen.show(mm)
 	}
 	err := mm.mat.Close()
 	if err != nil {
@@ -13,7 +13,7 @@ const (
 	verticalAlpha  = 0.7086 // 40.6 degrees
 	horizontalBeta = 0.7854 // 45 degrees
 
-	eyeHeight = 60 // cm
+	defaultEyeHeight = 60 // cm
 
 	PixelWidth   = 640
 	PixelHeight  = 480
@@ -21,12 +21,19 @@ const (
 )
 
 type DistanceLookup struct {
+	// eyeHeight is the height of the camera in cm
+	eyeHeight  float64
 	xDistances gocv.Mat
 	yDistances gocv.Mat
 }
 
 func NewDistanceLookup() *DistanceLookup {
-	dl := DistanceLookup{}
+	return NewDistanceLookupFor(defaultEyeHeight)
+}
+
+// NewDistanceLookupFor calculates the distances for a camera at eyeHeight in cm
+func NewDistanceLookupFor(eyeHeight float64) *DistanceLookup {
+	dl := DistanceLookup{eyeHeight: eyeHeight}
 
 	dl.xDistances = gocv.NewMatWithSize(PixelWidth, PixelHeight-PixelHorizon, gocv.MatTypeCV32F)
 	dl.yDistances = gocv.NewMatWithSize(PixelWidth, PixelHeight-PixelHorizon, gocv.MatTypeCV32F)
@@ -35,14 +42,25 @@ func NewDistanceLookup() *DistanceLookup {
 	return &dl
 }
 
-var distInstance *DistanceLookup
-var distOnce sync.Once
+// the lookups are only read after init, so robots with the same eye height share one
+var distInstances = make(map[float64]*DistanceLookup)
+var distLock sync.Mutex
 
+// SharedDistanceLookup returns the lookup for the default eye height
 func SharedDistanceLookup() *DistanceLookup {
-	distOnce.Do(func() {
-		distInstance = NewDistanceLookup()
-	})
-	return distInstance
+	return SharedDistanceLookupFor(defaultEyeHeight)
+}
+
+// SharedDistanceLookupFor returns the lookup for eyeHeight in cm, it is calculated on first use
+func SharedDistanceLookupFor(eyeHeight float64) *DistanceLookup {
+	distLock.Lock()
+	defer distLock.Unlock()
+	d, ok := distInstances[eyeHeight]
+	if !ok {
+		d = NewDistanceLookupFor(eyeHeight)
+		distInstances[eyeHeight] = d
+	}
+	return d
 }
 
 func (d *DistanceLookup) init() {
@@ -66,11 +84,11 @@ func (d *DistanceLookup) calcDistance(x, y int) {
 	tX, tY := transform(x, y)
 	v := PixelHeight/2 - (PixelHeight - tY)
 	vTanGamma := v * math.Tan(verticalAlpha) / (PixelHeight / 2)
-	dy := eyeHeight / vTanGamma
+	dy := d.eyeHeight / vTanGamma
 	d.yDistances.SetFloatAt(x, y-PixelHorizon, float32(dy))
 
 	h := tX - PixelWidth/2
-	width := math.Tan(horizontalBeta) * eyeHeight / vTanGamma
+	width := math.Tan(horizontalBeta) * d.eyeHeight / vTanGamma
 	dx := h / (PixelWidth / 2) * width
 	d.xDistances.SetFloatAt(x, y-PixelHorizon, float32(dx))
 }
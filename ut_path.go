@@ -117,7 +117,7 @@ func computeControlPoints(a, b []float64, d []vg.Point) []vg.Point {
 	return x
 }
 
-func BezierPath(postits []Feature, startX, startY float64) (BezierPathThroughKnots, error) {
+func BezierPath(distances *DistanceLookup, postits []Feature, startX, startY float64) (BezierPathThroughKnots, error) {
 	var current = vg.Point{
 		X: vg.Length(startX),
 		Y: vg.Length(startY),
@@ -130,7 +130,7 @@ func BezierPath(postits []Feature, startX, startY float64) (BezierPathThroughKno
 	copy(copiedPits, postits)
 
 	for len(copiedPits) > 0 {
-		nearest, d, err := extractNearest(current, &copiedPits)
+		nearest, d, err := extractNearest(distances, current, &copiedPits)
 
 		// only consider postits in 200cm distance to current
 		if err != nil || d > 200 {
@@ -144,7 +144,7 @@ func BezierPath(postits []Feature, startX, startY float64) (BezierPathThroughKno
 }
 
 // gets postit that is nearest to pos and removes it from pits.Positions
-func extractNearest(pos vg.Point, pits *[]Feature) (vg.Point, float64, error) {
+func extractNearest(distances *DistanceLookup, pos vg.Point, pits *[]Feature) (vg.Point, float64, error) {
 	var nearest vg.Point
 	var index = -1
 	var distance = 500.0 // 5m
@@ -155,9 +155,9 @@ func extractNearest(pos vg.Point, pits *[]Feature) (vg.Point, float64, error) {
 			continue
 		}
 
-		qdx, qdy := SharedDistanceLookup().Distance(q.imagePos.X, q.imagePos.Y)
+		qdx, qdy := distances.Distance(q.imagePos.X, q.imagePos.Y)
 
-		d := SharedDistanceLookup().Euclidean(float64(pos.X), float64(pos.Y), qdx, qdy)
+		d := distances.Euclidean(float64(pos.X), float64(pos.Y), qdx, qdy)
 
 		if d < distance {
 			nearest = vg.Point{vg.Length(qdx), vg.Length(qdy)}
@@ -190,7 +190,7 @@ func TestBezierPath() {
 		{imagePos: image.Point{370, 450}},
 	}
 
-	path, _ := BezierPath(p, 40, 0)
+	path, _ := BezierPath(SharedDistanceLookup(), p, 40, 0)
 	fmt.Println(path)
 }
 
@@ -7,15 +7,6 @@ import (
 	"strings"
 )
 
-func flattenData(dataMap map[uint32][]byte) []byte {
-	totalBytes := (len(dataMap)-1)*(maxPacketSize-headerSize) + len(dataMap[uint32(len(dataMap))-1])
-	fullData := make([]byte, totalBytes)
-	for i := uint32(0); i < uint32(len(dataMap)); i++ {
-		copy(fullData[i*(maxPacketSize-headerSize):], dataMap[i])
-	}
-	return fullData
-}
-
 func signumF32(val float32) int {
 	switch {
 	case val < 0: